@@ -16,7 +16,10 @@ import (
 // AdminSocket exposes an admin socket for loooking at information about
 // the service, as well as what sites are mapped
 type AdminSocket struct {
-	sites []Site
+	sites     []Site
+	failures  []Failure
+	rejected  []Rejection
+	conflicts []Conflict
 	sync.Mutex
 }
 
@@ -39,6 +42,19 @@ func (a *AdminSocket) Update(sites []Site) error {
 	return nil
 }
 
+// Report is used to set the containers that could not be inspected or were
+// not proxied during the last update, as well as any conflicting claims to
+// a site, which the admin socket returns from /failures, /rejected and
+// /conflicts
+func (a *AdminSocket) Report(r Report) error {
+	a.Lock()
+	a.failures = r.Failures
+	a.rejected = r.Rejected
+	a.conflicts = r.Conflicts
+	a.Unlock()
+	return nil
+}
+
 // Start listens at the specified file for HTTP requests. It is blocking, so
 // it should be run in a goroutine
 func (a *AdminSocket) Start(asok string) error {
@@ -49,14 +65,73 @@ func (a *AdminSocket) Start(asok string) error {
 	}
 	r := mux.NewRouter()
 	r.HandleFunc("/sites", a.dumpSites)
+	r.HandleFunc("/projects", a.dumpProjects)
+	r.HandleFunc("/failures", a.dumpFailures)
+	r.HandleFunc("/rejected", a.dumpRejected)
+	r.HandleFunc("/conflicts", a.dumpConflicts)
 	r.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 	return http.Serve(l, r)
 }
 
+// dumpSites returns every site, or only those of a compose project and
+// service if the project and service query parameters are set
 func (a *AdminSocket) dumpSites(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+	project, service := r.FormValue("project"), r.FormValue("service")
+	if project == "" && service == "" {
+		a.dump(w, "sites", a.sites)
+		return
+	}
+	out := []Site{}
+	for _, v := range a.sites {
+		if (project == "" || v.Project == project) && (service == "" || v.Service == service) {
+			out = append(out, v)
+		}
+	}
+	a.dump(w, "sites", out)
+}
+
+// dumpProjects returns the IDs of the sites of every compose project and
+// service, sites that aren't part of a project are left out
+func (a *AdminSocket) dumpProjects(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+	out := map[string]map[string][]string{}
+	for _, v := range a.sites {
+		if v.Project == "" {
+			continue
+		}
+		if out[v.Project] == nil {
+			out[v.Project] = map[string][]string{}
+		}
+		out[v.Project][v.Service] = append(out[v.Project][v.Service], v.ID)
+	}
+	a.dump(w, "projects", out)
+}
+
+func (a *AdminSocket) dumpFailures(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+	a.dump(w, "failures", a.failures)
+}
+
+func (a *AdminSocket) dumpRejected(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+	a.dump(w, "rejected", a.rejected)
+}
+
+func (a *AdminSocket) dumpConflicts(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+	a.dump(w, "conflicts", a.conflicts)
+}
+
+func (a *AdminSocket) dump(w http.ResponseWriter, name string, v interface{}) {
 	data := bytes.NewBuffer(nil)
-	if err := json.NewEncoder(data).Encode(a.sites); err != nil {
-		http.Error(w, "unable to serialize sites", 500)
+	if err := json.NewEncoder(data).Encode(v); err != nil {
+		http.Error(w, "unable to serialize "+name, 500)
 		return
 	}
 	io.Copy(w, data)
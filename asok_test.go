@@ -0,0 +1,58 @@
+package dockerproxy
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAdminSocketProjects(t *testing.T) {
+	a := &AdminSocket{}
+	a.Update([]Site{
+		{ID: "api.google.com", Project: "shop", Service: "api"},
+		{ID: "www.google.com", Project: "shop", Service: "web"},
+		{ID: "www.yahoo.com", Project: "news", Service: "web"},
+		{ID: "static.google.com"},
+	})
+
+	tt := []struct {
+		query string
+		want  []string
+	}{
+		{query: "", want: []string{"api.google.com", "www.google.com", "www.yahoo.com", "static.google.com"}},
+		{query: "?project=shop", want: []string{"api.google.com", "www.google.com"}},
+		{query: "?project=shop&service=web", want: []string{"www.google.com"}},
+		{query: "?service=web", want: []string{"www.google.com", "www.yahoo.com"}},
+		{query: "?project=none", want: nil},
+	}
+	for _, v := range tt {
+		w := httptest.NewRecorder()
+		a.dumpSites(w, httptest.NewRequest("GET", "/sites"+v.query, nil))
+		var sites []Site
+		if err := json.NewDecoder(w.Body).Decode(&sites); err != nil {
+			t.Fatalf("unable to decode sites: %s", err)
+		}
+		var got []string
+		for _, s := range sites {
+			got = append(got, s.ID)
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Fatalf("wanted sites for %q: %v, got: %v", v.query, v.want, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	a.dumpProjects(w, httptest.NewRequest("GET", "/projects", nil))
+	var projects map[string]map[string][]string
+	if err := json.NewDecoder(w.Body).Decode(&projects); err != nil {
+		t.Fatalf("unable to decode projects: %s", err)
+	}
+	want := map[string]map[string][]string{
+		"shop": {"api": {"api.google.com"}, "web": {"www.google.com"}},
+		"news": {"web": {"www.yahoo.com"}},
+	}
+	if !reflect.DeepEqual(projects, want) {
+		t.Fatalf("wanted projects: %#v, got: %#v", want, projects)
+	}
+}
@@ -0,0 +1,125 @@
+package dockerproxy
+
+import (
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// inspector inspects a single container, which is satisfied by both the
+// Docker client and the inspect cache
+type inspector interface {
+	InspectContainer(id string) (*docker.Container, error)
+}
+
+// inspectCache holds the result of inspecting containers between updates,
+// so that one container changing causes one inspect rather than one for
+// every running container. Entries are invalidated by the Docker events
+// for the container, and the whole cache is flushed when events may have
+// been missed.
+type inspectCache struct {
+	containers map[string]*docker.Container
+	// epoch is bumped on every invalidation, so that an inspect racing
+	// with an event isn't cached with the state from before the event
+	epoch uint64
+
+	sync.Mutex
+}
+
+func newInspectCache() *inspectCache {
+	return &inspectCache{containers: map[string]*docker.Container{}}
+}
+
+// cached returns an inspector that answers from the cache, falling back to
+// client for containers that aren't cached
+func (c *inspectCache) cached(client inspector) inspector {
+	return &cachedInspector{cache: c, client: client}
+}
+
+func (c *inspectCache) get(id string) (*docker.Container, uint64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	info, ok := c.containers[id]
+	return info, c.epoch, ok
+}
+
+func (c *inspectCache) put(id string, info *docker.Container, epoch uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.epoch == epoch {
+		c.containers[id] = info
+	}
+}
+
+// invalidate removes a single container from the cache, including if it
+// was cached under a short ID
+func (c *inspectCache) invalidate(id string) {
+	c.Lock()
+	defer c.Unlock()
+	c.epoch++
+	for k, v := range c.containers {
+		if k == id || v.ID == id {
+			delete(c.containers, k)
+		}
+	}
+}
+
+// flush removes every container from the cache
+func (c *inspectCache) flush() {
+	c.Lock()
+	defer c.Unlock()
+	c.epoch++
+	c.containers = map[string]*docker.Container{}
+}
+
+// retain removes every container from the cache that isn't in ids, so
+// that containers which have gone away don't linger
+func (c *inspectCache) retain(ids []string) {
+	keep := make(map[string]bool, len(ids))
+	for _, v := range ids {
+		keep[v] = true
+	}
+	c.Lock()
+	defer c.Unlock()
+	for k, v := range c.containers {
+		if !keep[k] && !keep[v.ID] {
+			delete(c.containers, k)
+		}
+	}
+}
+
+// invalidateEvent removes the container an event is about from the cache.
+// Network events carry the network as the actor, with the container as
+// an attribute.
+func (c *inspectCache) invalidateEvent(ev *docker.APIEvents) {
+	switch ev.Type {
+	case "container":
+		c.invalidate(ev.Actor.ID)
+	case "network":
+		if id, ok := ev.Actor.Attributes["container"]; ok {
+			c.invalidate(id)
+		}
+	case "":
+		// events from before Docker 1.10 have no type, and are always
+		// about a container
+		c.invalidate(ev.ID)
+	}
+}
+
+type cachedInspector struct {
+	cache  *inspectCache
+	client inspector
+}
+
+func (c *cachedInspector) InspectContainer(id string) (*docker.Container, error) {
+	info, epoch, ok := c.cache.get(id)
+	if ok {
+		return info, nil
+	}
+	info, err := c.client.InspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.put(id, info, epoch)
+	return info, nil
+}
@@ -0,0 +1,76 @@
+package dockerproxy
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// countingInspector counts how many times each container is inspected
+type countingInspector struct {
+	calls map[string]int
+}
+
+func (c *countingInspector) InspectContainer(id string) (*docker.Container, error) {
+	c.calls[id]++
+	return &docker.Container{ID: id}, nil
+}
+
+func TestInspectCache(t *testing.T) {
+	client := &countingInspector{calls: map[string]int{}}
+	cache := newInspectCache()
+	inspect := cache.cached(client)
+
+	inspectAll := func(ids ...string) {
+		for _, v := range ids {
+			if _, err := inspect.InspectContainer(v); err != nil {
+				t.Fatalf("wanted no error inspecting, got: %s", err)
+			}
+		}
+	}
+
+	inspectAll("a", "b", "c")
+	inspectAll("a", "b", "c")
+	if client.calls["a"] != 1 || client.calls["b"] != 1 || client.calls["c"] != 1 {
+		t.Fatalf("wanted one inspect per container, got: %v", client.calls)
+	}
+
+	cache.invalidateEvent(&docker.APIEvents{Type: "container", Action: "start", Actor: docker.APIActor{ID: "b"}})
+	inspectAll("a", "b", "c")
+	if client.calls["a"] != 1 || client.calls["b"] != 2 || client.calls["c"] != 1 {
+		t.Fatalf("wanted only the container from the event to be inspected, got: %v", client.calls)
+	}
+
+	cache.invalidateEvent(&docker.APIEvents{
+		Type:   "network",
+		Action: "connect",
+		Actor:  docker.APIActor{ID: "overlay-net", Attributes: map[string]string{"container": "c"}},
+	})
+	inspectAll("a", "b", "c")
+	if client.calls["a"] != 1 || client.calls["b"] != 2 || client.calls["c"] != 2 {
+		t.Fatalf("wanted only the container connected to be inspected, got: %v", client.calls)
+	}
+
+	cache.retain([]string{"a"})
+	inspectAll("a", "b")
+	if client.calls["a"] != 1 || client.calls["b"] != 3 {
+		t.Fatalf("wanted containers no longer running to be removed, got: %v", client.calls)
+	}
+
+	cache.flush()
+	inspectAll("a")
+	if client.calls["a"] != 2 {
+		t.Fatalf("wanted flush to remove every container, got: %v", client.calls)
+	}
+}
+
+func TestInspectCacheRace(t *testing.T) {
+	cache := newInspectCache()
+	_, epoch, _ := cache.get("a")
+	// an event arrives while "a" is being inspected
+	cache.invalidate("a")
+	cache.put("a", &docker.Container{ID: "a"}, epoch)
+	if _, _, ok := cache.get("a"); ok {
+		t.Fatalf("wanted inspect from before an event not to be cached")
+	}
+}
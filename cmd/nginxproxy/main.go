@@ -28,6 +28,16 @@ func main() {
 			Usage:  "Leading Docker server to pull containers from",
 			EnvVar: "DOCKER_HOST",
 		},
+		cli.BoolFlag{
+			Name:   "docker.swarm",
+			Usage:  "Proxy swarm services instead of containers, the leader must be a swarm manager",
+			EnvVar: "DOCKER_SWARM",
+		},
+		cli.BoolFlag{
+			Name:   "docker.aggregate",
+			Usage:  "Proxy containers from the followers as well as the leader, through their published ports",
+			EnvVar: "DOCKER_AGGREGATE",
+		},
 		cli.BoolFlag{
 			Name:   "docker.tls",
 			Usage:  "Connect to Docker with TLS",
@@ -38,16 +48,78 @@ func main() {
 			Usage:  "Location of Docker TLS certs",
 			EnvVar: "DOCKER_CERT_PATH",
 		},
+		cli.IntFlag{
+			Name:   "docker.workers",
+			Value:  8,
+			Usage:  "Number of containers to inspect at once",
+			EnvVar: "DOCKER_WORKERS",
+		},
+		cli.BoolFlag{
+			Name:   "docker.lenient-env",
+			Usage:  "Proxy containers with malformed environment lines, ignoring the lines",
+			EnvVar: "DOCKER_LENIENT_ENV",
+		},
+		cli.StringFlag{
+			Name:   "conflicts",
+			Value:  "merge",
+			Usage:  "What to do when unrelated containers claim the same host: merge, oldest, newest or reject",
+			EnvVar: "CONFLICT_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "policy",
+			Usage:  "JSON file restricting which containers may claim which hosts",
+			EnvVar: "HOST_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "docker.labels",
+			Usage:  "Only proxy containers with all of these labels, as name or name=value",
+			EnvVar: "DOCKER_LABELS",
+		},
+		cli.StringFlag{
+			Name:   "docker.network",
+			Usage:  "Only proxy containers attached to this network",
+			EnvVar: "DOCKER_NETWORK",
+		},
+		cli.StringFlag{
+			Name:   "static",
+			Usage:  "JSON file of sites to proxy that aren't containers",
+			EnvVar: "STATIC_SITES",
+		},
 		cli.StringFlag{
 			Name:   "followers, f",
 			Usage:  "Follower Docker servers to poll for events",
 			EnvVar: "DOCKER_FOLLOWERS",
 		},
+		cli.BoolFlag{
+			Name:   "host.mode",
+			Usage:  "Run on the host instead of in a container, routing to bridge IPs or published ports",
+			EnvVar: "HOST_MODE",
+		},
+		cli.StringFlag{
+			Name:   "host.networks",
+			Usage:  "Docker networks reachable from the host in host mode (default: bridge)",
+			EnvVar: "HOST_NETWORKS",
+		},
 		cli.StringFlag{
 			Name:   "nginx.conf",
 			Usage:  "nginx config to write",
 			EnvVar: "NGINX_CONF",
 		},
+		cli.StringFlag{
+			Name:   "nginx.stream",
+			Usage:  "nginx stream config to write for tcp and udp sites, included outside of the http block",
+			EnvVar: "NGINX_STREAM_CONF",
+		},
+		cli.StringFlag{
+			Name:   "default-host",
+			Usage:  "host to send requests for unknown hosts to, instead of answering with a 503",
+			EnvVar: "DEFAULT_HOST",
+		},
+		cli.StringFlag{
+			Name:   "nginx.fallback",
+			Usage:  "HTML page to answer requests for unknown hosts with, when there is no default host",
+			EnvVar: "NGINX_FALLBACK_PAGE",
+		},
 		cli.StringFlag{
 			Name:   "nginx.certs",
 			Value:  "/opt/nginxssl",
@@ -69,6 +141,16 @@ func main() {
 			Usage:  "syslog server to send nginx and nginxproxy logs",
 			EnvVar: "SYSLOG_HOST",
 		},
+		cli.StringFlag{
+			Name:   "asok",
+			Usage:  "Path of a unix socket to serve the admin API on",
+			EnvVar: "ADMIN_SOCKET",
+		},
+		cli.BoolFlag{
+			Name:   "debug",
+			Usage:  "Enable debug logging, including why containers were not proxied",
+			EnvVar: "DEBUG",
+		},
 		cli.StringFlag{
 			Name:   "sentry",
 			Usage:  "Sentry DSN for error logs shipping to sentry",
@@ -79,6 +161,10 @@ func main() {
 }
 
 func realMain(c *cli.Context) {
+	if c.Bool("debug") {
+		log.SetLevel(log.DebugLevel)
+	}
+
 	if loghost := c.String("syslog"); loghost != "" {
 		if hook, err := syslogrus.NewSyslogHook("udp", loghost, syslog.LOG_INFO, "nginxproxy"); err == nil {
 			log.SetFormatter(&log.JSONFormatter{})
@@ -100,14 +186,42 @@ func realMain(c *cli.Context) {
 	}
 
 	cfg := dockerproxy.DockerConfig{
-		Leader: c.String("docker.leader"),
-		TLS:    c.Bool("docker.tls"),
+		Leader:      c.String("docker.leader"),
+		Swarm:       c.Bool("docker.swarm"),
+		Aggregate:   c.Bool("docker.aggregate"),
+		Workers:     c.Int("docker.workers"),
+		LenientEnv:  c.Bool("docker.lenient-env"),
+		Conflicts:   dockerproxy.ConflictPolicy(c.String("conflicts")),
+		Network:     c.String("docker.network"),
+		Streams:     c.String("nginx.stream") != "",
+		StaticSites: c.String("static"),
+		DefaultHost: c.String("default-host"),
+		TLS:         c.Bool("docker.tls"),
+	}
+
+	if name := c.String("policy"); name != "" {
+		policy, err := dockerproxy.LoadHostPolicy(name)
+		if err != nil {
+			log.Fatalf("error loading host policy: %s", err)
+		}
+		cfg.HostPolicy = policy
+	}
+
+	if labels := c.String("docker.labels"); labels != "" {
+		cfg.Labels = strings.Split(labels, ",")
 	}
 
 	if followers := c.String("followers"); followers != "" {
 		cfg.Watchers = strings.Split(followers, ",")
 	}
 
+	if c.Bool("host.mode") {
+		cfg.HostMode = true
+		if nets := c.String("host.networks"); nets != "" {
+			cfg.HostNetworks = strings.Split(nets, ",")
+		}
+	}
+
 	if dir := c.String("docker.certs"); dir != "" {
 		cfg.Cert = filepath.Join(dir, "cert.pem")
 		cfg.CA = filepath.Join(dir, "ca.pem")
@@ -129,11 +243,28 @@ func realMain(c *cli.Context) {
 	if loghost := c.String("syslog"); loghost != "" {
 		nginx.Syslog = loghost
 	}
+	nginx.StreamConfig = c.String("nginx.stream")
+	if page := c.String("nginx.fallback"); page != "" {
+		if !filepath.IsAbs(page) {
+			log.Fatalf("the fallback page must be an absolute path, got: %s", page)
+		}
+		nginx.FallbackPage = page
+	}
 
 	m.HandleSignals(syscall.SIGHUP)
 
 	m.Register(nginx)
 
+	if path := c.String("asok"); path != "" {
+		asok := &dockerproxy.AdminSocket{}
+		m.Register(asok)
+		go func() {
+			if err := asok.Start(path); err != nil {
+				log.WithError(err).Error("admin socket stopped")
+			}
+		}()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	m.Start(ctx, 10*time.Minute)
 
@@ -0,0 +1,162 @@
+package dockerproxy
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what happens when unrelated containers claim the
+// same host and path
+type ConflictPolicy string
+
+// Policies for resolving conflicting claims
+const (
+	// ConflictMerge proxies to every claimant, as if they were one service
+	ConflictMerge ConflictPolicy = "merge"
+	// ConflictOldest proxies to the owner with the oldest container
+	ConflictOldest ConflictPolicy = "oldest"
+	// ConflictNewest proxies to the owner whose oldest container is newest
+	ConflictNewest ConflictPolicy = "newest"
+	// ConflictReject doesn't proxy the site at all
+	ConflictReject ConflictPolicy = "reject"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	swarmServiceLabel   = "com.docker.swarm.service.name"
+	stackLabel          = "com.docker.stack.namespace"
+)
+
+var (
+	// ErrUnknownPolicy is returned when the conflict policy isn't one of
+	// merge, oldest, newest or reject
+	ErrUnknownPolicy = errors.New("unknown conflict policy, use merge, oldest, newest or reject")
+)
+
+// Conflict records a site that was claimed by more than one owner, and how
+// it was resolved. Winner is the owner that was proxied to, if the policy
+// picked one. Conflicts over tcp and udp sites have a Proto and Listen
+// port instead of a Host and Path.
+type Conflict struct {
+	Host   string
+	Path   string
+	Proto  string `json:",omitempty"`
+	Listen int64  `json:",omitempty"`
+	Owners []string
+	Policy ConflictPolicy
+	Winner string `json:",omitempty"`
+}
+
+// validPolicy reports whether p is a known policy. An empty policy is the
+// same as merge, which is how sites were always grouped.
+func validPolicy(p ConflictPolicy) bool {
+	switch p {
+	case "", ConflictMerge, ConflictOldest, ConflictNewest, ConflictReject:
+		return true
+	}
+	return false
+}
+
+// containerOwner returns who a container belongs to, so that replicas of
+// the same service aren't treated as conflicting with each other. Compose
+// and swarm services are identified by their labels. Anything else is its
+// own owner, as neither sharing an image nor running different images says
+// whether containers belong together.
+func containerOwner(labels map[string]string, id string) string {
+	if project, ok := labels[composeProjectLabel]; ok {
+		return "compose:" + project + "/" + labels[composeServiceLabel]
+	}
+	if svc, ok := labels[swarmServiceLabel]; ok {
+		return "service:" + svc
+	}
+	return "container:" + id
+}
+
+// owner is everyone claiming a site that belongs to the same service,
+// along with when the earliest of them was created
+type owner struct {
+	Name    string
+	Created time.Time
+}
+
+// siteOwners returns the distinct owners of backends, oldest first
+func siteOwners(backends []Backend) []owner {
+	index := map[string]int{}
+	var out []owner
+	for _, b := range backends {
+		i, ok := index[b.Owner]
+		if !ok {
+			index[b.Owner] = len(out)
+			out = append(out, owner{Name: b.Owner, Created: b.Created})
+			continue
+		}
+		if b.Created.Before(out[i].Created) {
+			out[i].Created = b.Created
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Created.Equal(out[j].Created) {
+			return out[i].Created.Before(out[j].Created)
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// resolveConflicts finds every site claimed by more than one owner, and
+// applies policy to it. Every conflict is recorded in the report, and any
+// backend that is no longer proxied to is rejected.
+func (r *Report) resolveConflicts(policy ConflictPolicy) {
+	out := make([]Site, 0, len(r.Sites))
+	for _, s := range r.Sites {
+		owners := siteOwners(s.Backends)
+		if len(owners) < 2 {
+			out = append(out, s)
+			continue
+		}
+
+		c := Conflict{Host: s.Host, Path: s.Path, Policy: policy}
+		if isStream(s.Proto) {
+			c.Proto, c.Listen = s.Proto, s.Listen
+		}
+		for _, v := range owners {
+			c.Owners = append(c.Owners, v.Name)
+		}
+		switch policy {
+		case ConflictOldest:
+			c.Winner = owners[0].Name
+		case ConflictNewest:
+			c.Winner = owners[len(owners)-1].Name
+		case ConflictReject:
+		default:
+			c.Policy = ConflictMerge
+			r.Conflicts = append(r.Conflicts, c)
+			out = append(out, s)
+			continue
+		}
+		r.Conflicts = append(r.Conflicts, c)
+
+		detail := "site is claimed by " + strings.Join(c.Owners, ", ")
+		if c.Winner != "" {
+			detail += ", and " + c.Winner + " won under the " + string(policy) + " policy"
+		}
+		var keep []Backend
+		for _, b := range s.Backends {
+			if b.Owner == c.Winner {
+				keep = append(keep, b)
+				continue
+			}
+			r.reject(b.ID, b.Names, s.Host, ReasonConflict, detail)
+		}
+		if len(keep) == 0 {
+			continue
+		}
+		s.Backends = keep
+		fillSite(&s)
+		out = append(out, s)
+	}
+	r.Sites = out
+}
@@ -0,0 +1,126 @@
+package dockerproxy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContainerOwner(t *testing.T) {
+	tt := []struct {
+		labels map[string]string
+		id     string
+		want   string
+	}{
+		{
+			labels: map[string]string{composeProjectLabel: "shop", composeServiceLabel: "web"},
+			id:     "abc",
+			want:   "compose:shop/web",
+		},
+		{
+			labels: map[string]string{swarmServiceLabel: "shop_web"},
+			id:     "abc",
+			want:   "service:shop_web",
+		},
+		{
+			labels: nil,
+			id:     "abc",
+			want:   "container:abc",
+		},
+	}
+	for _, v := range tt {
+		if got := containerOwner(v.labels, v.id); got != v.want {
+			t.Fatalf("wanted owner: %q, got: %q", v.want, got)
+		}
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	old := time.Unix(1000, 0)
+	backends := []Backend{
+		{ID: "a1", Owner: "compose:shop/web", Created: old, Config: map[string]string{"NGINX_A": "1"}},
+		{ID: "a2", Owner: "compose:shop/web", Created: old.Add(time.Hour)},
+		{ID: "b1", Owner: "container:b1", Created: old.Add(time.Minute), Config: map[string]string{"NGINX_B": "1"}},
+	}
+	replicas := []Backend{
+		{ID: "c1", Owner: "compose:shop/api", Created: old},
+		{ID: "c2", Owner: "compose:shop/api", Created: old},
+	}
+	owners := []string{"compose:shop/web", "container:b1"}
+	tt := []struct {
+		policy   ConflictPolicy
+		backends []string
+		config   map[string]string
+		winner   string
+		rejected []string
+	}{
+		{policy: "", backends: []string{"a1", "a2", "b1"}, config: backends[0].Config, rejected: nil},
+		{policy: ConflictMerge, backends: []string{"a1", "a2", "b1"}, config: backends[0].Config, rejected: nil},
+		{policy: ConflictOldest, backends: []string{"a1", "a2"}, config: backends[0].Config, winner: "compose:shop/web", rejected: []string{"b1"}},
+		{policy: ConflictNewest, backends: []string{"b1"}, config: backends[2].Config, winner: "container:b1", rejected: []string{"a1", "a2"}},
+		{policy: ConflictReject, backends: nil, winner: "", rejected: []string{"a1", "a2", "b1"}},
+	}
+	for _, v := range tt {
+		r := &Report{Sites: []Site{
+			{ID: "app.google.com", Host: "app.google.com", Path: "/", Backends: append([]Backend(nil), backends...), Config: backends[0].Config},
+			{ID: "www.google.com", Host: "www.google.com", Path: "/", Backends: replicas},
+		}}
+		r.resolveConflicts(v.policy)
+
+		if len(r.Conflicts) != 1 {
+			t.Fatalf("wanted 1 conflict with policy %q, got: %#v", v.policy, r.Conflicts)
+		}
+		c := r.Conflicts[0]
+		if c.Host != "app.google.com" || c.Winner != v.winner || !reflect.DeepEqual(c.Owners, owners) {
+			t.Fatalf("wanted conflict for app.google.com won by %q, got: %#v", v.winner, c)
+		}
+
+		var got []string
+		for _, s := range r.Sites {
+			if s.Host != "app.google.com" {
+				continue
+			}
+			for _, b := range s.Backends {
+				got = append(got, b.ID)
+			}
+			if !reflect.DeepEqual(s.Config, v.config) {
+				t.Fatalf("wanted config with policy %q: %#v, got: %#v", v.policy, v.config, s.Config)
+			}
+		}
+		if !reflect.DeepEqual(got, v.backends) {
+			t.Fatalf("wanted backends with policy %q: %v, got: %v", v.policy, v.backends, got)
+		}
+		if v.backends == nil && len(r.Sites) != 1 {
+			t.Fatalf("wanted the conflicting site to be removed, got: %#v", r.Sites)
+		}
+
+		var rejected []string
+		for _, x := range r.Rejected {
+			if x.Reason != ReasonConflict || x.Host != "app.google.com" {
+				t.Fatalf("wanted conflict rejection for app.google.com, got: %#v", x)
+			}
+			rejected = append(rejected, x.ID)
+		}
+		if !reflect.DeepEqual(rejected, v.rejected) {
+			t.Fatalf("wanted rejected with policy %q: %v, got: %v", v.policy, v.rejected, rejected)
+		}
+	}
+}
+
+func TestResolveStreamConflicts(t *testing.T) {
+	r := &Report{Sites: []Site{{
+		ID:     "tcp_5432",
+		Proto:  "tcp",
+		Listen: 5432,
+		Backends: []Backend{
+			{ID: "a", Owner: "compose:shop/db"},
+			{ID: "b", Owner: "compose:blog/db"},
+		},
+	}}}
+	r.resolveConflicts(ConflictMerge)
+
+	want := []Conflict{{Proto: "tcp", Listen: 5432, Owners: []string{"compose:blog/db", "compose:shop/db"}, Policy: ConflictMerge}}
+	if !reflect.DeepEqual(r.Conflicts, want) {
+		t.Fatalf("wanted conflicts: %#v, got: %#v", want, r.Conflicts)
+	}
+}
@@ -0,0 +1,46 @@
+package dockerproxy
+
+import (
+	"sort"
+	"strings"
+)
+
+// resolveDefault marks the sites of the default host, which is host if it
+// is set, and otherwise the host of any site with a backend that asked to
+// be the default. If more than one host asked, none of them are the
+// default, and every backend that asked is rejected.
+func (r *Report) resolveDefault(host string) {
+	if host == "" {
+		claims := map[string][]Backend{}
+		for _, s := range r.Sites {
+			for _, b := range s.Backends {
+				if b.Default {
+					claims[s.Host] = append(claims[s.Host], b)
+				}
+			}
+		}
+		var hosts []string
+		for h := range claims {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+		if len(hosts) > 1 {
+			detail := defaultKey + " is set for more than one host: " + strings.Join(hosts, ", ")
+			for _, h := range hosts {
+				for _, b := range claims[h] {
+					r.reject(b.ID, b.Names, h, ReasonConflict, detail)
+				}
+			}
+			return
+		}
+		if len(hosts) == 0 {
+			return
+		}
+		host = hosts[0]
+	}
+	for i := range r.Sites {
+		if r.Sites[i].Host == host {
+			r.Sites[i].Default = true
+		}
+	}
+}
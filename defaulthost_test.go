@@ -0,0 +1,62 @@
+package dockerproxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveDefault(t *testing.T) {
+	site := func(host string, backends ...Backend) Site {
+		return Site{ID: host, Host: host, Path: "/", Backends: backends}
+	}
+	tt := []struct {
+		host     string
+		sites    []Site
+		want     []string
+		rejected []string
+	}{
+		{
+			sites: []Site{site("a.com", Backend{ID: "a"}), site("b.com", Backend{ID: "b"})},
+		},
+		{
+			sites: []Site{site("a.com", Backend{ID: "a"}), site("b.com", Backend{ID: "b", Default: true})},
+			want:  []string{"b.com"},
+		},
+		{
+			host:  "a.com",
+			sites: []Site{site("a.com", Backend{ID: "a"}), site("b.com", Backend{ID: "b", Default: true})},
+			want:  []string{"a.com"},
+		},
+		{
+			host:  "c.com",
+			sites: []Site{site("a.com", Backend{ID: "a"}), site("b.com", Backend{ID: "b"})},
+		},
+		{
+			sites: []Site{
+				site("a.com", Backend{ID: "a", Default: true}),
+				site("b.com", Backend{ID: "b", Default: true}, Backend{ID: "c"}),
+			},
+			rejected: []string{"a", "b"},
+		},
+	}
+	for _, v := range tt {
+		r := &Report{Sites: v.sites}
+		r.resolveDefault(v.host)
+
+		var got, rejected []string
+		for _, s := range r.Sites {
+			if s.Default {
+				got = append(got, s.Host)
+			}
+		}
+		for _, x := range r.Rejected {
+			if x.Reason != ReasonConflict {
+				t.Fatalf("wanted a conflict rejection, got: %#v", x)
+			}
+			rejected = append(rejected, x.ID)
+		}
+		if !reflect.DeepEqual(got, v.want) || !reflect.DeepEqual(rejected, v.rejected) {
+			t.Fatalf("wanted default %v and rejected %v for %q, got: %v and %v", v.want, v.rejected, v.host, got, rejected)
+		}
+	}
+}
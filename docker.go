@@ -0,0 +1,167 @@
+package dockerproxy
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"golang.org/x/net/context"
+)
+
+// dockerProvider discovers sites from Docker, either from the containers
+// on the leader (or on every engine, when aggregating), or from the
+// services in the swarm. Changes are found by listening for events on the
+// leader and every follower.
+type dockerProvider struct {
+	cache *inspectCache
+	d     DockerConfig
+}
+
+// newDockerProvider creates a provider for cfg, and pings the leader to
+// make sure the configuration is valid. When aggregating, every follower
+// has to be reached over TCP.
+func newDockerProvider(cfg DockerConfig) (*dockerProvider, error) {
+	if cfg.Aggregate {
+		if err := checkFollowers(cfg.Watchers); err != nil {
+			return nil, err
+		}
+	}
+	p := &dockerProvider{cache: newInspectCache(), d: cfg}
+	// test our leader to make sure our docker connection works
+	if _, err := p.newClient(cfg.Leader, 0); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *dockerProvider) Name() string {
+	return "docker"
+}
+
+func (p *dockerProvider) Discover() (*Report, error) {
+	client, err := p.newClient(p.d.Leader, 0)
+	if err != nil {
+		return nil, err
+	}
+	return p.mapSites(client)
+}
+
+func (p *dockerProvider) Watch(ctx context.Context) (<-chan struct{}, error) {
+	changes := make(chan struct{})
+	log.Info("starting docker event poller(s)")
+	go p.watcher(p.d.Leader, ctx, changes)
+	for _, v := range p.d.Watchers {
+		go p.watcher(v, ctx, changes)
+	}
+	return changes, nil
+}
+
+// Reset flushes the inspect cache, so every container is inspected again
+func (p *dockerProvider) Reset() {
+	p.cache.flush()
+}
+
+func (p *dockerProvider) watcher(addr string, ctx context.Context, changes chan<- struct{}) {
+	tries := 0
+	for {
+		ll := log.WithField("host", addr)
+		client, err := p.newClient(addr, tries)
+		tries++
+		if err != nil {
+			ll.WithError(err).Error("error connecting to Docker")
+			continue
+		}
+		watcher := make(chan *docker.APIEvents)
+		if err := client.AddEventListener(watcher); err != nil {
+			ll.WithError(err).Error("error starting listener")
+			continue
+		}
+		tries = 0
+		// events may have been missed while we weren't listening
+		p.cache.flush()
+		ll.Info("polling...")
+	Watch:
+		for {
+			var err error
+			select {
+			case ev, ok := <-watcher:
+				if !ok {
+					ll.Info("listener closed")
+					break Watch
+				}
+				if ev == nil {
+					continue
+				}
+				ll := eventLogger(ll, ev)
+				ll.Info("received event")
+				// only tracked events invalidate the cache, since
+				// healthchecks constantly generate exec events
+				if isTracked(ev) {
+					p.cache.invalidateEvent(ev)
+					select {
+					case changes <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			case <-time.After(1 * time.Hour):
+				break Watch
+			case <-time.After(30 * time.Second):
+				err = client.Ping()
+			}
+			if err != nil {
+				ll.WithError(err).Error("listener error")
+				break Watch
+			}
+		}
+	}
+}
+
+// mapSites pulls the list of sites from Docker, either from the services
+// in the swarm, or from the containers running on the leader or on every
+// engine
+func (p *dockerProvider) mapSites(client *docker.Client) (*Report, error) {
+	inspect := p.cache.cached(client)
+	me, err := findSelf(inspect, p.d)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.d.Swarm {
+		return mapServices(client, me, p.d)
+	}
+
+	if p.d.Aggregate {
+		return p.mapEngines(client, me)
+	}
+
+	containers, err := client.ListContainers(containerListOptions(p.d))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(containers))
+	for _, v := range containers {
+		ids = append(ids, v.ID)
+	}
+	p.cache.retain(ids)
+
+	return mapContainers(inspect, me, p.d, containers)
+}
+
+// newClient produces a new Docker connection and checks to make sure that it can ping
+// the Docker socket. It will properly back off and throttle to avoid too many connections
+func (p *dockerProvider) newClient(addr string, tries int) (*docker.Client, error) {
+	backoff(tries)
+	log.WithField("addr", addr).Info("creating client")
+	client, err := newDockerClient(addr, p.d.TLS, p.d.Cert, p.d.CA, p.d.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
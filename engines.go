@@ -0,0 +1,92 @@
+package dockerproxy
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// ErrFollowerAddress is returned when aggregating with a follower that
+	// isn't reached over TCP, as its published ports have no address
+	ErrFollowerAddress = errors.New("aggregated followers must be tcp:// endpoints")
+)
+
+// mapEngines is mapContainers for every engine the proxy is configured
+// with, rather than just the leader, with the sites of every engine merged
+// together. Containers on the leader are routed to as usual, while those
+// on followers are routed to through their published ports, at the
+// address of the follower. A follower that can't be listed is recorded as
+// a failure against its Engine, so that one engine going away doesn't stop
+// updates.
+func (p *dockerProvider) mapEngines(leader *docker.Client, me self) (*Report, error) {
+	report := &Report{}
+	var list []Site
+	var ids []string
+	for i, addr := range append([]string{p.d.Leader}, p.d.Watchers...) {
+		client, engine := leader, me
+		if i > 0 {
+			c, err := p.newClient(addr, 0)
+			if err != nil {
+				report.Failures = append(report.Failures, Failure{Engine: addr, Error: err.Error()})
+				continue
+			}
+			client, engine = c, self{Address: engineAddress(addr), Remote: true}
+		}
+
+		containers, err := client.ListContainers(containerListOptions(p.d))
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			report.Failures = append(report.Failures, Failure{Engine: addr, Error: err.Error()})
+			continue
+		}
+		for _, v := range containers {
+			ids = append(ids, v.ID)
+		}
+
+		r, err := mapContainers(p.cache.cached(client), engine, p.d, containers)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range r.Sites {
+			for j := range s.Backends {
+				s.Backends[j].Engine = addr
+			}
+			list = append(list, s)
+		}
+		for j := range r.Failures {
+			r.Failures[j].Engine = addr
+		}
+		report.Failures = append(report.Failures, r.Failures...)
+		report.Rejected = append(report.Rejected, r.Rejected...)
+	}
+	p.cache.retain(ids)
+
+	report.Sites = groupSites(list)
+	return report, nil
+}
+
+// engineAddress returns the address published ports on an engine are
+// reached at, which is the host of its TCP endpoint. There is no address
+// for engines reached over a unix socket or named pipe.
+func engineAddress(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "tcp" {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// checkFollowers makes sure every follower has an address its published
+// ports can be reached at
+func checkFollowers(watchers []string) error {
+	for _, v := range watchers {
+		if engineAddress(v) == "" {
+			return ErrFollowerAddress
+		}
+	}
+	return nil
+}
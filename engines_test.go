@@ -0,0 +1,99 @@
+package dockerproxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestEngineAddress(t *testing.T) {
+	tt := []struct {
+		addr string
+		want string
+	}{
+		{addr: "tcp://10.0.0.5:2376", want: "10.0.0.5"},
+		{addr: "tcp://docker-2.example.com:2375", want: "docker-2.example.com"},
+		{addr: "unix:///var/run/docker.sock", want: ""},
+		{addr: "npipe:////./pipe/docker_engine", want: ""},
+	}
+	for _, v := range tt {
+		if got := engineAddress(v.addr); got != v.want {
+			t.Fatalf("wanted address: %q, got: %q", v.want, got)
+		}
+	}
+}
+
+func TestMapEngines(t *testing.T) {
+	a, aList := fakeContainer("a", "www.google.com", "10.0.1.2")
+	leader := fakeEngine(0, []docker.APIContainers{aList}, a)
+	defer leader.Close()
+
+	b, bList := fakeContainer("b", "www.google.com", "10.0.9.2")
+	b.NetworkSettings.Ports["80/tcp"] = []docker.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}}
+	bList.Networks.Networks = map[string]docker.ContainerNetwork{"bridge": {IPAddress: "172.17.0.2"}}
+	c, cList := fakeContainer("c", "api.google.com", "10.0.9.3")
+	c.NetworkSettings.Ports["80/tcp"] = []docker.PortBinding{{HostIP: "127.0.0.1", HostPort: "8081"}}
+	cList.Networks.Networks = map[string]docker.ContainerNetwork{"bridge": {IPAddress: "172.17.0.3"}}
+	follower := fakeEngine(0, []docker.APIContainers{bList, cList}, b, c)
+	defer follower.Close()
+
+	leaderAddr := strings.Replace(leader.URL, "http://", "tcp://", 1)
+	followerAddr := strings.Replace(follower.URL, "http://", "tcp://", 1)
+	p, err := newDockerProvider(DockerConfig{
+		Leader:    leaderAddr,
+		Watchers:  []string{followerAddr, "tcp://127.0.0.1:1"},
+		Aggregate: true,
+	})
+	if err != nil {
+		t.Fatalf("unable to create provider: %s", err)
+	}
+	client, err := p.newClient(leaderAddr, 0)
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+
+	report, err := p.mapEngines(client, me)
+	if err != nil {
+		t.Fatalf("wanted no error mapping engines, got: %s", err)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Engine != "tcp://127.0.0.1:1" || report.Failures[0].ID != "" {
+		t.Fatalf("wanted a failure for the unreachable engine, got: %#v", report.Failures)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].ID != "c" || report.Rejected[0].Reason != ReasonNoRoute {
+		t.Fatalf("wanted the port published on the follower's loopback rejected, got: %#v", report.Rejected)
+	}
+	if len(report.Sites) != 1 {
+		t.Fatalf("wanted the sites of both engines merged, got: %#v", report.Sites)
+	}
+
+	site := report.Sites[0]
+	engines := []string{leaderAddr, followerAddr}
+	if leaderAddr > followerAddr {
+		engines = []string{followerAddr, leaderAddr}
+	}
+	if !reflect.DeepEqual(site.Engines, engines) {
+		t.Fatalf("wanted engines: %v, got: %v", engines, site.Engines)
+	}
+	want := map[string]Mapping{
+		"a": {Address: "10.0.1.2", Port: 80, Network: "overlay-net"},
+		"b": {Address: "127.0.0.1", Port: 8080, Network: "public"},
+	}
+	for _, v := range site.Backends {
+		if v.Contact != want[v.ID] {
+			t.Fatalf("wanted mapping for %s: %#v, got: %#v", v.ID, want[v.ID], v.Contact)
+		}
+	}
+}
+
+func TestAggregateFollowers(t *testing.T) {
+	_, err := newDockerProvider(DockerConfig{
+		Leader:    "tcp://127.0.0.1:1",
+		Watchers:  []string{"tcp://10.0.0.5:2376", "unix:///var/run/docker.sock"},
+		Aggregate: true,
+	})
+	if err != ErrFollowerAddress {
+		t.Fatalf("wanted err: %v, got: %v", ErrFollowerAddress, err)
+	}
+}
@@ -0,0 +1,76 @@
+package dockerproxy
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxHostLength  = 253
+	maxLabelLength = 63
+)
+
+var (
+	// ErrEmptyHost is returned when a host, or part of a wildcard host, is empty
+	ErrEmptyHost = errors.New("host is empty")
+
+	// ErrHostTooLong is returned when a host is longer than DNS allows
+	ErrHostTooLong = errors.New("host is longer than 253 characters")
+
+	// ErrInvalidLabel is returned when part of a host isn't a valid RFC 1123 label
+	ErrInvalidLabel = errors.New("host labels must be 1-63 letters, digits or hyphens, and not start or end with a hyphen")
+)
+
+// normalizeHost returns the form of a host that is written to nginx: lower
+// cased, without a trailing dot, and with any internationalized labels
+// converted to punycode. Wildcards are allowed as the first or last label,
+// as nginx accepts them in server_name, while everything else must be a
+// valid RFC 1123 hostname.
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimSpace(host), ".")
+
+	var prefix, suffix string
+	switch {
+	case strings.HasPrefix(host, "*."):
+		prefix, host = "*.", host[2:]
+	case strings.HasSuffix(host, ".*"):
+		suffix, host = ".*", host[:len(host)-2]
+	}
+	if host == "" {
+		return "", ErrEmptyHost
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", err
+	}
+	ascii = strings.ToLower(ascii)
+	if len(prefix)+len(ascii)+len(suffix) > maxHostLength {
+		return "", ErrHostTooLong
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if !validLabel(label) {
+			return "", ErrInvalidLabel
+		}
+	}
+	return prefix + ascii + suffix, nil
+}
+
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > maxLabelLength {
+		return false
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package dockerproxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tt := []struct {
+		host string
+		want string
+		ok   bool
+	}{
+		{host: "www.google.com", want: "www.google.com", ok: true},
+		{host: " WWW.Google.COM ", want: "www.google.com", ok: true},
+		{host: "www.google.com.", want: "www.google.com", ok: true},
+		{host: "localhost", want: "localhost", ok: true},
+		{host: "bücher.example", want: "xn--bcher-kva.example", ok: true},
+		{host: "xn--bcher-kva.example", want: "xn--bcher-kva.example", ok: true},
+		{host: "*.google.com", want: "*.google.com", ok: true},
+		{host: "www.google.*", want: "www.google.*", ok: true},
+		{host: "*.", want: "", ok: false},
+		{host: "", want: "", ok: false},
+		{host: "bad_host.google.com", want: "", ok: false},
+		{host: "bad host.google.com", want: "", ok: false},
+		{host: "-google.com", want: "", ok: false},
+		{host: "google..com", want: "", ok: false},
+		{host: "www.*.google.com", want: "", ok: false},
+		{host: strings.Repeat("a", 64) + ".com", want: "", ok: false},
+		{host: strings.Repeat("a.", 127) + "com", want: "", ok: false},
+	}
+	for _, v := range tt {
+		got, err := normalizeHost(v.host)
+		if ok := err == nil; ok != v.ok {
+			t.Fatalf("wanted ok for %q: %v, got err: %v", v.host, v.ok, err)
+		}
+		if got != v.want {
+			t.Fatalf("wanted host: %q, got: %q", v.want, got)
+		}
+	}
+}
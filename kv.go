@@ -2,10 +2,30 @@ package dockerproxy
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 )
 
-const sep = "="
+const (
+	sep         = "="
+	labelPrefix = "docker-proxy."
+)
+
+// labelAliases maps label names that don't follow the usual
+// transformation to the environment variable they configure
+var labelAliases = map[string]string{
+	"host":        hostKey,
+	"port":        portKey,
+	"path":        pathKey,
+	"path.strip":  pathStripKey,
+	"healthcheck": healthKey,
+	"weight":      weightKey,
+	"backup":      backupKey,
+	"down":        downKey,
+	"proto":       protoKey,
+	"listen":      listenKey,
+	"default":     defaultKey,
+}
 
 var (
 	// ErrInvalidLine is returned when a malformed environment line is passed
@@ -27,3 +47,65 @@ func parseKV(kv []string) (map[string]string, error) {
 	}
 	return out, nil
 }
+
+// parseKVLenient is parseKV for environments that may contain lines without
+// a value, as some images set. Every valid pair is kept, and a warning is
+// returned for each line that was skipped.
+func parseKVLenient(kv []string) (map[string]string, []string) {
+	out := map[string]string{}
+	var warnings []string
+	for _, v := range kv {
+		data := strings.SplitN(v, sep, 2)
+		if len(data) != 2 {
+			warnings = append(warnings, "ignored environment line without "+strconv.Quote(sep)+": "+strconv.Quote(v))
+			continue
+		}
+		out[data[0]] = data[1]
+	}
+	return out, warnings
+}
+
+// parseEnv parses the environment of a container, only failing on malformed
+// lines if lenient isn't set
+func parseEnv(kv []string, lenient bool) (map[string]string, []string, error) {
+	if !lenient {
+		out, err := parseKV(kv)
+		return out, nil, err
+	}
+	out, warnings := parseKVLenient(kv)
+	return out, warnings, nil
+}
+
+// labelsToKV converts any docker-proxy labels into the name of the environment
+// variable they configure. Labels in labelAliases, such as `docker-proxy.host`,
+// are renamed to their VIRTUAL_* variable, every other label is upper cased with
+// dots replaced by underscores, so `docker-proxy.nginx.client_max_body_size`
+// becomes NGINX_CLIENT_MAX_BODY_SIZE. Labels without the prefix are ignored.
+func labelsToKV(labels map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range labels {
+		if !strings.HasPrefix(k, labelPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, labelPrefix)
+		if alias, ok := labelAliases[name]; ok {
+			out[alias] = v
+			continue
+		}
+		out[strings.ToUpper(strings.Replace(name, ".", "_", -1))] = v
+	}
+	return out
+}
+
+// mergeKV returns the configuration of a container, with any labels taking
+// precedence over environment variables of the same name
+func mergeKV(env, labels map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range env {
+		out[k] = v
+	}
+	for k, v := range labelsToKV(labels) {
+		out[k] = v
+	}
+	return out
+}
@@ -33,3 +33,75 @@ func TestKV(t *testing.T) {
 		}
 	}
 }
+
+func TestKVLenient(t *testing.T) {
+	tt := []struct {
+		strs     []string
+		want     map[string]string
+		warnings int
+	}{
+		{
+			strs:     []string{"a=b", "d=d=d"},
+			want:     map[string]string{"a": "b", "d": "d=d"},
+			warnings: 0,
+		},
+		{
+			strs:     []string{"a", "b=c", "EMPTY="},
+			want:     map[string]string{"b": "c", "EMPTY": ""},
+			warnings: 1,
+		},
+	}
+
+	for _, v := range tt {
+		got, warnings := parseKVLenient(v.strs)
+		if len(warnings) != v.warnings {
+			t.Fatalf("want %d warnings, got: %#v", v.warnings, warnings)
+		}
+		if !reflect.DeepEqual(v.want, got) {
+			t.Fatalf("want: %#v, got: %#v", v.want, got)
+		}
+	}
+}
+
+func TestMergeKV(t *testing.T) {
+	tt := []struct {
+		env    map[string]string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			env:    map[string]string{"VIRTUAL_HOST": "www.google.com"},
+			labels: map[string]string{},
+			want:   map[string]string{"VIRTUAL_HOST": "www.google.com"},
+		},
+		{
+			env: map[string]string{},
+			labels: map[string]string{
+				"docker-proxy.host":                           "www.google.com",
+				"docker-proxy.port":                           "9001",
+				"docker-proxy.nginx.client_max_body_size":     "5m",
+				"com.docker.compose.project":                  "ignored",
+				"docker-proxy.cert_name":                      "google",
+				"not-docker-proxy.nginx.client_max_body_size": "1m",
+			},
+			want: map[string]string{
+				"VIRTUAL_HOST":               "www.google.com",
+				"VIRTUAL_PORT":               "9001",
+				"NGINX_CLIENT_MAX_BODY_SIZE": "5m",
+				"CERT_NAME":                  "google",
+			},
+		},
+		{
+			env:    map[string]string{"VIRTUAL_HOST": "www.yahoo.com", "VIRTUAL_PORT": "80"},
+			labels: map[string]string{"docker-proxy.host": "www.google.com"},
+			want:   map[string]string{"VIRTUAL_HOST": "www.google.com", "VIRTUAL_PORT": "80"},
+		},
+	}
+
+	for _, v := range tt {
+		got := mergeKV(v.env, v.labels)
+		if !reflect.DeepEqual(v.want, got) {
+			t.Fatalf("want: %#v, got: %#v", v.want, got)
+		}
+	}
+}
@@ -6,29 +6,67 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/fsouza/go-dockerclient"
 	"golang.org/x/net/context"
 )
 
 // Manager handles the state and coordinates updates of the mappings
-// of Containers -> Sites
+// of Containers -> Sites, merging the sites of every provider
 type Manager struct {
-	notify []watcher
-	update chan struct{}
-
-	d DockerConfig
+	notify      []watcher
+	providers   []Provider
+	update      chan struct{}
+	conflicts   ConflictPolicy
+	defaultHost string
 }
 
 // DockerConfig contains the hosts/sockets of the Docker API to pull
 // a list of containers from, as well as any other watchers to
-// trigger events from
+// trigger events from.
 type DockerConfig struct {
 	Watchers []string
 	Leader   string
-	TLS      bool
-	Cert     string
-	CA       string
-	Key      string
+	// Swarm proxies the services of the swarm the Leader manages, instead
+	// of containers
+	Swarm bool
+	// Aggregate proxies containers from the Leader and every one of the
+	// Watchers, rather than only the Leader
+	Aggregate bool
+	// HostMode is set when the proxy is running on the host rather than
+	// in a container, in which case it routes to containers on
+	// HostNetworks (the default bridge network if empty), or their
+	// published ports
+	HostMode     bool
+	HostNetworks []string
+	// Workers is the number of containers inspected at once during an
+	// update
+	Workers int
+	// LenientEnv proxies containers with malformed environment lines,
+	// ignoring the lines that couldn't be parsed
+	LenientEnv bool
+	// Conflicts is the policy for when unrelated containers claim the
+	// same site, which defaults to merging them into one upstream
+	Conflicts ConflictPolicy
+	// HostPolicy restricts containers to the hosts it allows, if set
+	HostPolicy *HostPolicy
+	// Labels and Network select the containers this instance of the proxy
+	// is responsible for, so that several instances can watch the same
+	// engine. Labels are either a name or name=value, and must all match.
+	Labels  []string
+	Network string
+	// StaticSites is a JSON file of sites to proxy alongside the
+	// discovered ones, which is reloaded whenever it changes
+	StaticSites string
+	// Streams enables tcp and udp sites, which are otherwise rejected, and
+	// should only be set if the watchers can serve them
+	Streams bool
+	// DefaultHost is the host requests for unknown hosts are sent to. If
+	// it isn't set, containers can ask for their hosts to be the default
+	// host with VIRTUAL_DEFAULT, as long as only one host does.
+	DefaultHost string
+	TLS         bool
+	Cert        string
+	CA          string
+	Key         string
 }
 
 type watcher interface {
@@ -36,22 +74,57 @@ type watcher interface {
 	Name() string
 }
 
+// reporter is implemented by watchers that also want the full report of
+// each update, including any containers that had to be skipped
+type reporter interface {
+	Report(Report) error
+}
+
 // New creates a new manager with the specified Docker configuration.
 // it will Ping the Leader to make sure the configuration is valid.
 func New(cfg DockerConfig) (*Manager, error) {
-	m := &Manager{
-		update: make(chan struct{}),
-		d:      cfg,
+	if !validPolicy(cfg.Conflicts) {
+		return nil, ErrUnknownPolicy
+	}
+	if cfg.DefaultHost != "" {
+		host, err := normalizeHost(cfg.DefaultHost)
+		if err != nil {
+			return nil, err
+		}
+		cfg.DefaultHost = host
 	}
-	// test our leader to make sure our docker connection works
-	if _, err := m.newClient(cfg.Leader, 0); err != nil {
+	docker, err := newDockerProvider(cfg)
+	if err != nil {
 		return nil, err
 	}
+	m := newManager(cfg.Conflicts, docker)
+	m.defaultHost = cfg.DefaultHost
+	if cfg.StaticSites != "" {
+		static := &staticSites{name: cfg.StaticSites}
+		if err := static.load(); err != nil {
+			return nil, err
+		}
+		m.AddProvider(static)
+	}
 	return m, nil
 }
 
-// Start begins waiting for events on the Docker endpoint, as well as
-// polling every `d` seconds
+func newManager(conflicts ConflictPolicy, providers ...Provider) *Manager {
+	return &Manager{
+		providers: providers,
+		update:    make(chan struct{}),
+		conflicts: conflicts,
+	}
+}
+
+// AddProvider adds a provider whose sites are proxied alongside the
+// containers. It must be called before Start.
+func (m *Manager) AddProvider(p Provider) {
+	m.providers = append(m.providers, p)
+}
+
+// Start begins watching every provider for changes, as well as polling
+// every `d` seconds
 func (m *Manager) Start(ctx context.Context, d time.Duration) {
 	// send an initial update request
 	go func() { m.update <- struct{}{} }()
@@ -59,10 +132,8 @@ func (m *Manager) Start(ctx context.Context, d time.Duration) {
 	log.Info("starting update mechanism")
 	go m.updater(ctx)
 
-	log.Info("starting docker event poller(s)")
-	go m.watcher(m.d.Leader, ctx)
-	for _, v := range m.d.Watchers {
-		go m.watcher(v, ctx)
+	for _, p := range m.providers {
+		go m.forward(ctx, p)
 	}
 
 	log.Info("starting polling loop")
@@ -74,93 +145,32 @@ func (m *Manager) Register(w watcher) {
 	m.notify = append(m.notify, w)
 }
 
-func (m *Manager) watcher(addr string, ctx context.Context) {
-	tries := 0
-	for {
-		ll := log.WithField("host", addr)
-		client, err := m.newClient(addr, tries)
-		tries++
-		if err != nil {
-			ll.WithError(err).Error("error connecting to Docker")
-			continue
-		}
-		watcher := make(chan *docker.APIEvents)
-		if err := client.AddEventListener(watcher); err != nil {
-			ll.WithError(err).Error("error starting listener")
-			continue
-		}
-		tries = 0
-		ll.Info("polling...")
-	Watch:
-		for {
-			var err error
-			select {
-			case ev, ok := <-watcher:
-				if !ok {
-					ll.Info("listener closed")
-					break Watch
-				}
-				if ev == nil {
-					continue
-				}
-				ll := eventLogger(ll, ev)
-				ll.Info("received event")
-				if isTracked(ev) {
-					ll.Info("sending update")
-					m.update <- struct{}{}
-				}
-			case <-ctx.Done():
-				return
-			case <-time.After(1 * time.Hour):
-				break Watch
-			case <-time.After(30 * time.Second):
-				err = client.Ping()
-			}
-			if err != nil {
-				ll.WithError(err).Error("listener error")
-				break Watch
-			}
-		}
-	}
-}
-
 // HandleSignals listenes for the specified signals and reloads when they
-// are signaled
+// are signaled, re-inspecting every container
 func (m *Manager) HandleSignals(sigs ...os.Signal) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, sigs...)
 	go func() {
 		for range sigChan {
+			m.reset()
 			m.update <- struct{}{}
 		}
 	}()
 }
 
-// Poll checks the Docker Api every `d` seconds to look for changes
+// Poll checks every provider every `d` seconds to look for changes
 func (m *Manager) startPoll(ctx context.Context, d time.Duration) {
 	tkr := time.NewTicker(d)
 	defer tkr.Stop()
 	for {
 		select {
 		case <-tkr.C:
+			// polling is the fallback for missed events, so don't
+			// trust anything that was cached
+			m.reset()
 			m.update <- struct{}{}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
-
-// newClient produces a new Docker connection and checks to make sure that it can ping
-// the Docker socket. It will properly back off and throttle to avoid too many connections
-func (m *Manager) newClient(addr string, tries int) (*docker.Client, error) {
-	backoff(tries)
-	log.WithField("addr", addr).Info("creating client")
-	client, err := newDockerClient(addr, m.d.TLS, m.d.Cert, m.d.CA, m.d.Key)
-	if err != nil {
-		return nil, err
-	}
-	if err := client.Ping(); err != nil {
-		return nil, err
-	}
-	return client, nil
-}
@@ -0,0 +1,149 @@
+package dockerproxy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// memProvider is a Provider serving a fixed report from memory
+type memProvider struct {
+	name    string
+	report  Report
+	err     error
+	changes chan struct{}
+	resets  int
+}
+
+func (p *memProvider) Name() string { return p.name }
+
+func (p *memProvider) Discover() (*Report, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	r := p.report
+	return &r, nil
+}
+
+func (p *memProvider) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return p.changes, nil
+}
+
+func (p *memProvider) Reset() { p.resets++ }
+
+// memWatcher records every update and report it is sent
+type memWatcher struct {
+	sites   [][]Site
+	reports []Report
+}
+
+func (w *memWatcher) Name() string { return "memory" }
+
+func (w *memWatcher) Update(sites []Site) error {
+	w.sites = append(w.sites, sites)
+	return nil
+}
+
+func (w *memWatcher) Report(r Report) error {
+	w.reports = append(w.reports, r)
+	return nil
+}
+
+func memSite(host, id, owner string) Site {
+	return Site{ID: host, Host: host, Path: "/", Backends: []Backend{{ID: id, Owner: owner}}}
+}
+
+func TestManagerMergesProviders(t *testing.T) {
+	a := &memProvider{name: "a", report: Report{
+		Sites:    []Site{memSite("www.google.com", "a1", "compose:shop/web"), memSite("shared.google.com", "a2", "compose:shop/web")},
+		Rejected: []Rejection{{ID: "a3", Reason: ReasonNoHost}},
+	}}
+	b := &memProvider{name: "b", report: Report{
+		Sites:    []Site{memSite("www.yahoo.com", "b1", "static"), memSite("shared.google.com", "b2", "static")},
+		Failures: []Failure{{ID: "b3", Error: "gone"}},
+	}}
+	m := newManager(ConflictReject, a, b)
+	w := &memWatcher{}
+	m.Register(w)
+
+	if err := m.updateContainers(); err != nil {
+		t.Fatalf("wanted no error updating, got: %s", err)
+	}
+	if len(w.sites) != 1 || len(w.reports) != 1 {
+		t.Fatalf("wanted one update and report, got: %d updates and %d reports", len(w.sites), len(w.reports))
+	}
+
+	var hosts []string
+	for _, v := range w.sites[0] {
+		hosts = append(hosts, v.Host)
+	}
+	if want := []string{"www.google.com", "www.yahoo.com"}; !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("wanted hosts: %v, got: %v", want, hosts)
+	}
+
+	r := w.reports[0]
+	if len(r.Failures) != 1 || len(r.Rejected) != 3 || len(r.Conflicts) != 1 {
+		t.Fatalf("wanted the failures, rejections and conflicts of both providers, got: %#v", r)
+	}
+	if r.Conflicts[0].Host != "shared.google.com" {
+		t.Fatalf("wanted a conflict for shared.google.com, got: %#v", r.Conflicts[0])
+	}
+}
+
+func TestManagerProviderError(t *testing.T) {
+	a := &memProvider{name: "a", report: Report{Sites: []Site{memSite("www.google.com", "a1", "compose:shop/web")}}}
+	b := &memProvider{name: "b", err: errors.New("unavailable")}
+	m := newManager(ConflictMerge, a, b)
+	w := &memWatcher{}
+	m.Register(w)
+
+	if err := m.updateContainers(); err == nil {
+		t.Fatalf("wanted an error when a provider fails")
+	}
+	if len(w.sites) != 0 {
+		t.Fatalf("wanted watchers not to be updated, got: %#v", w.sites)
+	}
+}
+
+func TestManagerForwardsChanges(t *testing.T) {
+	p := &memProvider{name: "a", changes: make(chan struct{})}
+	m := newManager(ConflictMerge, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.forward(ctx, p)
+
+	p.changes <- struct{}{}
+	select {
+	case <-m.update:
+	case <-time.After(time.Second):
+		t.Fatalf("wanted a change to request an update")
+	}
+
+	m.reset()
+	if p.resets != 1 {
+		t.Fatalf("wanted the provider to be reset, got: %d resets", p.resets)
+	}
+}
+
+func TestManagerForwardStops(t *testing.T) {
+	p := &memProvider{name: "a", changes: make(chan struct{})}
+	m := newManager(ConflictMerge, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.forward(ctx, p)
+		close(done)
+	}()
+
+	// nothing reads the update, as if the updater had already stopped
+	p.changes <- struct{}{}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("wanted forward to stop once the context is done")
+	}
+}
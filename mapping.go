@@ -1,30 +1,105 @@
 package dockerproxy
 
 import (
-	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
-	"os"
-	"regexp"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
 
 const (
-	hostKey = "VIRTUAL_HOST"
-	portKey = "VIRTUAL_PORT"
+	hostKey      = "VIRTUAL_HOST"
+	portKey      = "VIRTUAL_PORT"
+	pathKey      = "VIRTUAL_PATH"
+	pathStripKey = "VIRTUAL_PATH_STRIP"
+	healthKey    = "VIRTUAL_HEALTHCHECK"
+	weightKey    = "VIRTUAL_WEIGHT"
+	backupKey    = "VIRTUAL_BACKUP"
+	downKey      = "VIRTUAL_DOWN"
+	protoKey     = "VIRTUAL_PROTO"
+	listenKey    = "VIRTUAL_LISTEN"
+	defaultKey   = "VIRTUAL_DEFAULT"
 )
 
-// Site represents a single containers' site, which consists
-// of the container the site is running in, the IP/Port necessary
-// to contact it, as well as 1+ virtual host to advertise.
+// httpPorts are the ports nginx serves HTTP sites on, which tcp and udp
+// sites can't listen on
+var httpPorts = []int64{80, 443}
+
+// Site represents a single virtual host and path, which consists of
+// every container advertising them, as well as the configuration of
+// the site. All of the backends share a single upstream.
 type Site struct {
-	ID      string
-	Names   []string
-	Contact Mapping
-	Hosts   []string
-	Env     map[string]string
+	ID   string
+	Host string
+	Path string
+	// Proto is the protocol the backends speak, which is plain HTTP if
+	// empty. Sites with a Proto of tcp or udp are streams, which have no
+	// Host or Path, and are served on the Listen port instead.
+	Proto  string `json:",omitempty"`
+	Listen int64  `json:",omitempty"`
+	// StripPath removes Path from the request before it is proxied
+	StripPath bool
+	// Project and Service are set if every backend belongs to the same
+	// compose project and service
+	Project  string `json:",omitempty"`
+	Service  string `json:",omitempty"`
+	Backends []Backend
+	Config   map[string]string
+	// Warnings collects the warnings of every backend, prefixed with the
+	// backend's ID
+	Warnings []string
+	// Engines lists the Docker engines the backends are running on, when
+	// containers are aggregated from more than one
+	Engines []string `json:",omitempty"`
+	// Default is set on every site of the host that requests for unknown
+	// hosts are sent to
+	Default bool `json:",omitempty"`
+}
+
+// Backend represents a single container serving a site, along with
+// the IP/Port necessary to contact it
+type Backend struct {
+	ID    string
+	Names []string
+	// Engine is the Docker engine the container is running on, when
+	// containers are aggregated from more than one
+	Engine string `json:",omitempty"`
+	// Project and Service are the compose project and service, or the
+	// swarm stack and service, of the container
+	Project string `json:",omitempty"`
+	Service string `json:",omitempty"`
+	// Owner identifies the service the container belongs to, which is
+	// used to tell replicas apart from conflicting claims to a site
+	Owner string
+	// Proto is the protocol the container speaks, which is empty for
+	// plain HTTP
+	Proto    string `json:",omitempty"`
+	Created  time.Time
+	Networks []string
+	Contact  Mapping
+	Env      map[string]string
+	Labels   map[string]string
+	// Config is the merged view of the container's environment and
+	// labels, with labels taking precedence
+	Config map[string]string
+	// Warnings are problems with the container that didn't stop it from
+	// being proxied, such as malformed environment lines in lenient mode
+	Warnings []string
+	// Weight, Backup and Down are the options of the container's server
+	// in the upstream, with a zero Weight left to nginx
+	Weight int  `json:",omitempty"`
+	Backup bool `json:",omitempty"`
+	Down   bool `json:",omitempty"`
+	// Default is set if the container asked for its hosts to be the
+	// default host with VIRTUAL_DEFAULT, and the host policy allowed it
+	Default bool `json:",omitempty"`
 }
 
 // Mapping represents an IP/Port as well as optional network name
@@ -35,61 +110,385 @@ type Mapping struct {
 	Network string
 }
 
-func mapContainers(client *docker.Client, containers []docker.APIContainers) ([]Site, error) {
-	list := make([]Site, 0, len(containers))
-	myNets, me, err := myInfo(client)
-	if err != nil {
-		return nil, err
-	}
+// defaultWorkers is the number of containers inspected at once if the
+// configuration doesn't say otherwise
+const defaultWorkers = 8
 
+func mapContainers(client inspector, me self, cfg DockerConfig, containers []docker.APIContainers) (*Report, error) {
+	others := make([]docker.APIContainers, 0, len(containers))
 	for _, v := range containers {
-		if v.ID == me {
-			continue
+		if v.ID != me.ID && selected(cfg, v.Labels, networkList(v.Networks)) {
+			others = append(others, v)
 		}
+	}
+	infos, errs := inspectContainers(client, others, cfg.Workers)
 
-		info, err := client.InspectContainer(v.ID)
+	list := make([]Site, 0, len(others))
+	report := &Report{}
+	for i, v := range others {
+		info, err := infos[i], errs[i]
 		if err != nil {
-			return nil, err
+			report.Failures = append(report.Failures, Failure{ID: v.ID, Names: v.Names, Error: err.Error()})
+			continue
 		}
-		env, err := parseKV(info.Config.Env)
+		env, warnings, err := parseEnv(info.Config.Env, cfg.LenientEnv)
 		if err != nil {
+			report.reject(v.ID, v.Names, "", ReasonInvalidEnv, err.Error())
 			continue
 		}
 
-		hosts, ok := findHosts(env)
+		b := Backend{
+			ID:       v.ID,
+			Names:    v.Names,
+			Project:  info.Config.Labels[composeProjectLabel],
+			Service:  info.Config.Labels[composeServiceLabel],
+			Owner:    containerOwner(info.Config.Labels, v.ID),
+			Created:  time.Unix(v.Created, 0),
+			Networks: networkList(v.Networks),
+			Env:      env,
+			Labels:   info.Config.Labels,
+			Config:   mergeKV(env, info.Config.Labels),
+			Warnings: warnings,
+		}
+		status := info.State.Health.Status
+		healthy, ok := isHealthy(status, b.Config)
 		if !ok {
+			report.reject(v.ID, v.Names, "", ReasonInvalidHealthcheck, "invalid "+healthKey+" "+strconv.Quote(b.Config[healthKey])+", must be starting or ignore")
+			continue
+		}
+		if !healthy {
+			report.reject(v.ID, v.Names, "", ReasonUnhealthy, "healthcheck status is "+status)
 			continue
 		}
 
 		portMap := info.NetworkSettings.PortMappingAPI()
-		port, _ := findPort(env)
-		mapping, ok := findMapping(myNets, v.Networks, portMap, port)
-		if !ok {
+		list = append(list, report.backendSites(b, cfg, func(port int64) (*Mapping, error) {
+			mapping, err := findMapping(me.Networks, v.Networks, portMap, port)
+			if err != nil || mapping.Network != "public" {
+				return mapping, err
+			}
+			// a port published on the loopback of another engine can only
+			// be reached from that engine
+			if me.Remote && isLoopback(mapping.Address) {
+				return nil, ErrNoRoute
+			}
+			if me.Address != "" {
+				mapping.Address = reachableAddress(mapping.Address, me.Address)
+			}
+			return mapping, nil
+		})...)
+	}
+	report.Sites = groupSites(list)
+	return report, nil
+}
+
+// inspectContainers inspects every container, with up to workers requests
+// in flight at once. The results are in the same order as containers, so
+// nothing depends on which requests finish first.
+func inspectContainers(client inspector, containers []docker.APIContainers, workers int) ([]*docker.Container, []error) {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	infos := make([]*docker.Container, len(containers))
+	errs := make([]error, len(containers))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				infos[j], errs[j] = client.InspectContainer(containers[j].ID)
+			}
+		}()
+	}
+	for i := range containers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return infos, errs
+}
+
+// backendSites returns a site for every host the backend is configured
+// for, using contact to find the Mapping for the port the host is served
+// from. The port is zero if neither the host nor VIRTUAL_PORT set one.
+// Hosts are normalized, with duplicates ignored, and must be authorized by
+// the host policy. Anything that can't be routed, including invalid or
+// unauthorized hosts, is added to the report's rejections.
+func (r *Report) backendSites(b Backend, cfg DockerConfig, contact func(port int64) (*Mapping, error)) []Site {
+	policy := cfg.HostPolicy
+	proto, ok := findProto(b.Config)
+	if !ok {
+		r.reject(b.ID, b.Names, "", ReasonInvalidProto, "invalid "+protoKey+" "+strconv.Quote(b.Config[protoKey]))
+		return nil
+	}
+	if isStream(proto) {
+		if !cfg.Streams {
+			r.reject(b.ID, b.Names, "", ReasonInvalidProto, proto+" sites are disabled, as there is no stream config to write them to")
+			return nil
+		}
+		return r.streamSites(b, proto, policy, contact)
+	}
+
+	hosts, ok := findHosts(b.Config)
+	if !ok {
+		r.reject(b.ID, b.Names, "", ReasonNoHost, hostKey+" is not set")
+		return nil
+	}
+
+	path, ok := findPath(b.Config)
+	if !ok {
+		r.reject(b.ID, b.Names, "", ReasonInvalidPath, "invalid "+pathKey+" "+strconv.Quote(b.Config[pathKey]))
+		return nil
+	}
+	if findPathStrip(b.Config) && !stripsPath(proto) {
+		r.reject(b.ID, b.Names, "", ReasonInvalidPath, pathStripKey+" can't be used with "+proto)
+		return nil
+	}
+	b.Proto = proto
+
+	if err := upstreamOptions(&b); err != nil {
+		r.reject(b.ID, b.Names, "", ReasonInvalidUpstream, err.Error())
+		return nil
+	}
+
+	if def, _ := strconv.ParseBool(b.Config[defaultKey]); def && cfg.DefaultHost == "" {
+		if policy.authorizedDefault(b) {
+			b.Default = true
+		} else {
+			r.reject(b.ID, b.Names, "", ReasonUnauthorizedHost, defaultKey+" is not allowed for this container by the host policy")
+		}
+	}
+
+	port, _ := findPort(b.Config)
+	// a host listed twice is only proxied once, and only if both agree on
+	// the port, as one host can't have two upstreams
+	seen := map[string]int64{}
+	var out []Site
+	for _, h := range hosts {
+		host, err := normalizeHost(h.Host)
+		if err != nil {
+			r.reject(b.ID, b.Names, h.Host, ReasonInvalidHost, err.Error())
+			continue
+		}
+		if h.Port == 0 {
+			h.Port = port
+		}
+		if prev, ok := seen[host]; ok {
+			if prev != h.Port {
+				r.reject(b.ID, b.Names, host, ReasonInvalidHost, "host is listed with ports "+strconv.FormatInt(prev, 10)+" and "+strconv.FormatInt(h.Port, 10))
+			}
+			continue
+		}
+		seen[host] = h.Port
+		h.Host = host
+		if !policy.authorized(host, b) {
+			r.reject(b.ID, b.Names, host, ReasonUnauthorizedHost, "host is not allowed for this container by the host policy")
 			continue
 		}
 
-		c := Site{
-			ID:      v.ID,
-			Names:   v.Names,
-			Contact: *mapping,
-			Hosts:   hosts,
-			Env:     env,
+		mapping, err := contact(h.Port)
+		if err != nil {
+			r.reject(b.ID, b.Names, h.Host, mappingReason(err), err.Error())
+			continue
 		}
-		list = append(list, c)
+
+		b.Contact = *mapping
+		out = append(out, Site{Host: h.Host, Path: path, Backends: []Backend{b}})
 	}
-	return list, nil
+	return out
 }
 
-func findHosts(env map[string]string) ([]string, bool) {
-	data, ok := env[hostKey]
+// streamSites returns the stream site for a backend with a Proto of tcp or
+// udp, which must set the port to listen on with VIRTUAL_LISTEN. The port
+// can't be one HTTP sites are served on, and must be authorized by policy.
+func (r *Report) streamSites(b Backend, proto string, policy *HostPolicy, contact func(port int64) (*Mapping, error)) []Site {
+	listen, ok := findListen(b.Config)
 	if !ok {
-		return nil, false
+		r.reject(b.ID, b.Names, "", ReasonInvalidListen, listenKey+" must be set to a port for "+proto)
+		return nil
+	}
+	for _, v := range httpPorts {
+		if listen == v {
+			r.reject(b.ID, b.Names, "", ReasonInvalidListen, listenKey+" "+strconv.FormatInt(listen, 10)+" is used by HTTP sites")
+			return nil
+		}
+	}
+	if !policy.authorizedPort(listen, b) {
+		r.reject(b.ID, b.Names, "", ReasonUnauthorizedHost, "port "+strconv.FormatInt(listen, 10)+" is not allowed for this container by the host policy")
+		return nil
+	}
+
+	if err := upstreamOptions(&b); err != nil {
+		r.reject(b.ID, b.Names, "", ReasonInvalidUpstream, err.Error())
+		return nil
+	}
+
+	port, _ := findPort(b.Config)
+	mapping, err := contact(port)
+	if err != nil {
+		r.reject(b.ID, b.Names, "", mappingReason(err), err.Error())
+		return nil
+	}
+	b.Contact = *mapping
+	return []Site{{Proto: proto, Listen: listen, Backends: []Backend{b}}}
+}
+
+// groupSites merges every site advertising the same host and path, or the
+// same stream protocol and port, into a single site containing all of
+// their backends. Sites are sorted by host and path, and backends by
+// container ID, so that the output is stable between updates. The
+// configuration of a site is taken from its first backend.
+func groupSites(sites []Site) []Site {
+	type key struct {
+		host, path, proto string
+		listen            int64
+	}
+	index := map[key]int{}
+	out := make([]Site, 0, len(sites))
+	for _, v := range sites {
+		k := key{host: v.Host, path: v.Path}
+		if isStream(v.Proto) {
+			k = key{proto: v.Proto, listen: v.Listen}
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(out)
+			index[k] = i
+			s := Site{Host: v.Host, Path: v.Path}
+			if isStream(v.Proto) {
+				s.Proto, s.Listen = v.Proto, v.Listen
+			}
+			out = append(out, s)
+		}
+		out[i].Backends = append(out[i].Backends, v.Backends...)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		if out[i].Listen != out[j].Listen {
+			return out[i].Listen < out[j].Listen
+		}
+		return out[i].Proto < out[j].Proto
+	})
+	for i := range out {
+		out[i].ID = siteID(out[i].Host, out[i].Path)
+		if isStream(out[i].Proto) {
+			out[i].ID = streamID(out[i].Proto, out[i].Listen)
+		}
+		b := out[i].Backends
+		sort.Slice(b, func(i, j int) bool { return b[i].ID < b[j].ID })
+		fillSite(&out[i])
+	}
+	return out
+}
+
+// fillSite sets the configuration, protocol, compose project and warnings
+// of a site from its sorted backends
+func fillSite(s *Site) {
+	s.Config = s.Backends[0].Config
+	if !isStream(s.Proto) {
+		s.Proto = s.Backends[0].Proto
+	}
+	s.StripPath = findPathStrip(s.Config) && s.Path != "/" && !isStream(s.Proto)
+	s.Project, s.Service = s.Backends[0].Project, s.Backends[0].Service
+	s.Warnings, s.Engines = nil, nil
+	for _, v := range s.Backends {
+		if v.Project != s.Project || v.Service != s.Service {
+			s.Project, s.Service = "", ""
+		}
+		for _, w := range v.Warnings {
+			s.Warnings = append(s.Warnings, v.ID+": "+w)
+		}
+		if v.Engine != "" && !contains(s.Engines, v.Engine) {
+			s.Engines = append(s.Engines, v.Engine)
+		}
+	}
+	sort.Strings(s.Engines)
+}
+
+// siteID returns the name of the upstream for a host and path, replacing
+// anything that nginx would not accept in an upstream name. Sites at the
+// root of a host are named after the host alone. As different hosts and
+// paths can be replaced with the same name, such as /a/b and /a_b, names
+// that had anything replaced end in a hash of the host and path.
+func siteID(host, path string) string {
+	name := host
+	if path != "/" {
+		name += path
+	}
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.' || r == '-' || r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	if id == name {
+		return id
+	}
+	sum := sha1.Sum([]byte(host + "\x00" + path))
+	return id + "-" + hex.EncodeToString(sum[:8])
+}
+
+// networkList returns the names of the networks a container is attached to
+func networkList(networks docker.NetworkList) []string {
+	out := make([]string, 0, len(networks.Networks))
+	for k := range networks.Networks {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// streamID returns the name of the upstream for a stream, such as tcp_5432
+func streamID(proto string, listen int64) string {
+	return proto + "_" + strconv.FormatInt(listen, 10)
+}
+
+// vhost is a single host advertised by a container, along with the port
+// of the container it is served from, if it was given as host:port
+type vhost struct {
+	Host string
+	Port int64
+}
+
+// findHosts returns every host in VIRTUAL_HOST, ignoring any whitespace
+// and empty entries. The hosts aren't validated, see normalizeHost.
+func findHosts(config map[string]string) ([]vhost, bool) {
+	var out []vhost
+	for _, v := range strings.Split(config[hostKey], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, parseHost(v))
+		}
 	}
-	return strings.Split(data, ","), true
+	return out, len(out) > 0
 }
 
-func findPort(env map[string]string) (int64, bool) {
-	data, ok := env[portKey]
+// parseHost splits a port from the end of a host, if it has one
+func parseHost(host string) vhost {
+	index := strings.LastIndex(host, ":")
+	if index == -1 {
+		return vhost{Host: host}
+	}
+	port, err := strconv.ParseInt(host[index+1:], 10, 64)
+	if err != nil || port <= 0 {
+		return vhost{Host: host}
+	}
+	return vhost{Host: host[:index], Port: port}
+}
+
+func findPort(config map[string]string) (int64, bool) {
+	data, ok := config[portKey]
 	if !ok {
 		return 0, false
 	}
@@ -97,10 +496,110 @@ func findPort(env map[string]string) (int64, bool) {
 	return int64(num), err == nil
 }
 
-func findMapping(my []string, you docker.NetworkList, ports []docker.APIPort, port int64) (*Mapping, bool) {
+// findPath returns the path a container should be served under, which
+// defaults to the root. Paths always start with a slash and never end
+// with one, unless they are the root.
+func findPath(config map[string]string) (string, bool) {
+	data, ok := config[pathKey]
+	if !ok {
+		return "/", true
+	}
+	if strings.ContainsAny(data, " \t\n;{}'\"") {
+		return "", false
+	}
+	return "/" + strings.Trim(data, "/"), true
+}
+
+// findProto returns the protocol a container is proxied with, which is
+// either empty for HTTP, another protocol an HTTP site can be passed to,
+// or tcp or udp for a stream
+func findProto(config map[string]string) (string, bool) {
+	proto := strings.ToLower(config[protoKey])
+	switch proto {
+	case "", "http":
+		return "", true
+	case "https", "grpc", "grpcs", "uwsgi", "fastcgi", "tcp", "udp":
+		return proto, true
+	}
+	return "", false
+}
+
+func isStream(proto string) bool {
+	return proto == "tcp" || proto == "udp"
+}
+
+// stripsPath reports whether VIRTUAL_PATH_STRIP can be used with proto, as
+// only proxy_pass can replace the path of a request
+func stripsPath(proto string) bool {
+	return proto == "" || proto == "https"
+}
+
+// findListen returns the port a stream is served on by the proxy
+func findListen(config map[string]string) (int64, bool) {
+	listen, err := strconv.ParseInt(config[listenKey], 10, 64)
+	return listen, err == nil && listen > 0 && listen <= 65535
+}
+
+func findPathStrip(config map[string]string) bool {
+	strip, err := strconv.ParseBool(config[pathStripKey])
+	return strip && err == nil
+}
+
+// upstreamOptions sets the weight, backup and down options of a backend
+// from its configuration. The weight must be a positive number, and backup
+// and down anything strconv.ParseBool accepts.
+func upstreamOptions(b *Backend) error {
+	if data, ok := b.Config[weightKey]; ok {
+		weight, err := strconv.Atoi(data)
+		if err != nil || weight <= 0 {
+			return errors.New("invalid " + weightKey + " " + strconv.Quote(data) + ", must be a positive number")
+		}
+		b.Weight = weight
+	}
+	flags := []struct {
+		key string
+		opt *bool
+	}{
+		{key: backupKey, opt: &b.Backup},
+		{key: downKey, opt: &b.Down},
+	}
+	for _, v := range flags {
+		data, ok := b.Config[v.key]
+		if !ok {
+			continue
+		}
+		flag, err := strconv.ParseBool(data)
+		if err != nil {
+			return errors.New("invalid " + v.key + " " + strconv.Quote(data) + ", must be true or false")
+		}
+		*v.opt = flag
+	}
+	return nil
+}
+
+// isHealthy reports whether a container can be routed to, given the status
+// of its healthcheck. By default, only containers without a healthcheck or
+// that are healthy are routed to. VIRTUAL_HEALTHCHECK can be set to
+// "starting" to also route to containers that haven't finished starting,
+// or "ignore" to route to containers regardless of their health. Any other
+// value of VIRTUAL_HEALTHCHECK isn't ok.
+func isHealthy(status string, config map[string]string) (bool, bool) {
+	switch config[healthKey] {
+	case "ignore":
+		return true, true
+	case "starting":
+		return status != "unhealthy", true
+	case "":
+		return status == "" || status == "none" || status == "healthy", true
+	default:
+		return false, false
+	}
+}
+
+func findMapping(my []string, you docker.NetworkList, ports []docker.APIPort, port int64) (*Mapping, error) {
 	data, ok := findAPIPort(ports, port)
 	if !ok {
-		return nil, false
+		return nil, ErrNoPort
 	}
 	for _, v := range my {
 		if net, ok := you.Networks[v]; ok {
@@ -108,7 +607,7 @@ func findMapping(my []string, you docker.NetworkList, ports []docker.APIPort, po
 				Address: net.IPAddress,
 				Network: v,
 				Port:    data.PrivatePort,
-			}, true
+			}, nil
 		}
 	}
 	if data.IP != "" && data.PublicPort != 0 {
@@ -116,73 +615,39 @@ func findMapping(my []string, you docker.NetworkList, ports []docker.APIPort, po
 			Address: data.IP,
 			Network: "public",
 			Port:    data.PublicPort,
-		}, true
+		}, nil
 	}
-	return nil, false
+	return nil, ErrNoRoute
 }
 
+// findAPIPort returns the exposed port matching port, which is either the
+// port inside the container or the one it is published on. Without a port,
+// the container has to expose exactly one.
 func findAPIPort(ports []docker.APIPort, port int64) (*docker.APIPort, bool) {
-	switch len(ports) {
-	case 0:
-		return nil, false
-	case 1:
-		return &ports[0], true
-	default:
-		if port == 0 {
+	if port == 0 {
+		if len(ports) != 1 {
 			return nil, false
 		}
-		for _, v := range ports {
-			if v.PrivatePort == port || v.PublicPort == port {
-				return &v, true
-			}
+		return &ports[0], true
+	}
+	for _, v := range ports {
+		if v.PrivatePort == port || v.PublicPort == port {
+			return &v, true
 		}
-		return nil, false
 	}
+	return nil, false
 }
 
-func myInfo(client *docker.Client) ([]string, string, error) {
-	me, err := currentContainerID()
-	if err != nil {
-		return nil, "", err
-	}
-
-	c, err := client.InspectContainer(me)
-	if err != nil {
-		return nil, me, err
-	}
-	out := make([]string, 0, len(c.NetworkSettings.Networks))
-	networks := c.NetworkSettings.Networks
-	for k := range networks {
-		out = append(out, k)
-	}
-	return out, me, nil
+func isLoopback(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
 }
 
-func currentContainerID() (string, error) {
-	if id, ok := os.LookupEnv("CONTAINER_ID"); ok {
-		return id, nil
+// reachableAddress returns fallback if addr is a wildcard address, which
+// Docker reports for ports published on every interface
+func reachableAddress(addr, fallback string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.IsUnspecified() {
+		return fallback
 	}
-
-	file, err := os.Open("/proc/self/cgroup")
-	if err != nil {
-		return "", err
-	}
-
-	reader := bufio.NewReader(file)
-	scanner := bufio.NewScanner(reader)
-	scanner.Split(bufio.ScanLines)
-	regex := "/docker/([[:alnum:]]{64})$"
-	re := regexp.MustCompilePOSIX(regex)
-
-	for scanner.Scan() {
-		_, lines, err := bufio.ScanLines([]byte(scanner.Text()), true)
-		if err == nil {
-			if re.MatchString(string(lines)) {
-				submatches := re.FindStringSubmatch(string(lines))
-				return submatches[1], nil
-			}
-		}
-	}
-
-	return "", errors.New("unable to fetch container ID")
+	return addr
 }
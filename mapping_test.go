@@ -1,21 +1,91 @@
 package dockerproxy
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
 
+// fakeDocker serves just enough of the Docker API to inspect the
+// containers it was created with, returning a 404 for anything else.
+// Each request takes at least latency, to simulate a busy daemon.
+func fakeDocker(t testing.TB, latency time.Duration, containers ...*docker.Container) (*docker.Client, func()) {
+	srv := fakeEngine(latency, nil, containers...)
+	client, err := docker.NewClient(srv.URL)
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+	return client, srv.Close
+}
+
+// fakeEngine is the server behind fakeDocker, which also answers pings and
+// lists the containers in list, regardless of any filters
+func fakeEngine(latency time.Duration, list []docker.APIContainers, containers ...*docker.Container) *httptest.Server {
+	byID := map[string]*docker.Container{}
+	for _, v := range containers {
+		byID[v.ID] = v
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case len(parts) == 1 && parts[0] == "_ping":
+			w.Write([]byte("OK"))
+			return
+		case len(parts) == 2 && parts[0] == "containers" && parts[1] == "json":
+			json.NewEncoder(w).Encode(list)
+			return
+		case len(parts) != 3 || parts[0] != "containers" || parts[2] != "json":
+			http.NotFound(w, r)
+			return
+		}
+		c, ok := byID[parts[1]]
+		if !ok {
+			http.Error(w, "no such container", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+	}))
+}
+
+// me is the proxy's own container in tests, attached to overlay-net
+var me = self{ID: "proxy", Networks: []string{"overlay-net"}}
+
+// fakeContainer returns a container advertising host on the overlay-net
+// network, along with its entry in the container list
+func fakeContainer(id, host, ip string) (*docker.Container, docker.APIContainers) {
+	c := &docker.Container{
+		ID:     id,
+		Config: &docker.Config{Env: []string{"VIRTUAL_HOST=" + host}},
+		NetworkSettings: &docker.NetworkSettings{
+			Ports: map[docker.Port][]docker.PortBinding{"80/tcp": nil},
+		},
+	}
+	list := docker.APIContainers{
+		ID:       id,
+		Image:    "nginx",
+		Names:    []string{"/" + id},
+		Networks: docker.NetworkList{Networks: map[string]docker.ContainerNetwork{"overlay-net": {IPAddress: ip}}},
+	}
+	return c, list
+}
+
 func TestHosts(t *testing.T) {
 	tt := []struct {
 		m    map[string]string
-		want []string
+		want []vhost
 		ok   bool
 	}{
 		{
 			m:    map[string]string{"VIRTUAL_HOST": "www.google.com"},
-			want: []string{"www.google.com"},
+			want: []vhost{{Host: "www.google.com"}},
 			ok:   true,
 		},
 		{
@@ -25,9 +95,29 @@ func TestHosts(t *testing.T) {
 		},
 		{
 			m:    map[string]string{"VIRTUAL_HOST": "www.google.com,google.com,yahoo.com"},
-			want: []string{"www.google.com", "google.com", "yahoo.com"},
+			want: []vhost{{Host: "www.google.com"}, {Host: "google.com"}, {Host: "yahoo.com"}},
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_HOST": "ui.google.com:8080,api.google.com:9090,google.com"},
+			want: []vhost{{Host: "ui.google.com", Port: 8080}, {Host: "api.google.com", Port: 9090}, {Host: "google.com"}},
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_HOST": "google.com:http"},
+			want: []vhost{{Host: "google.com:http"}},
 			ok:   true,
 		},
+		{
+			m:    map[string]string{"VIRTUAL_HOST": "a.com, B.com,"},
+			want: []vhost{{Host: "a.com"}, {Host: "B.com"}},
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_HOST": " , "},
+			want: nil,
+			ok:   false,
+		},
 	}
 	for _, v := range tt {
 		hosts, ok := findHosts(v.m)
@@ -73,6 +163,154 @@ func TestPorts(t *testing.T) {
 	}
 }
 
+func TestPaths(t *testing.T) {
+	tt := []struct {
+		m    map[string]string
+		want string
+		ok   bool
+	}{
+		{
+			m:    map[string]string{},
+			want: "/",
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_PATH": "/api"},
+			want: "/api",
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_PATH": "api/v1/"},
+			want: "/api/v1",
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_PATH": "/"},
+			want: "/",
+			ok:   true,
+		},
+		{
+			m:    map[string]string{"VIRTUAL_PATH": "/api; return 200"},
+			want: "",
+			ok:   false,
+		},
+	}
+	for _, v := range tt {
+		path, ok := findPath(v.m)
+		if ok != v.ok {
+			t.Errorf("wanted ok: %v, got: %v", v.ok, ok)
+		}
+		if path != v.want {
+			t.Fatalf("wanted path: %q, got: %q", v.want, path)
+		}
+	}
+}
+
+func TestUpstreamOptions(t *testing.T) {
+	tt := []struct {
+		config map[string]string
+		want   Backend
+		ok     bool
+	}{
+		{config: map[string]string{}, want: Backend{}, ok: true},
+		{config: map[string]string{"VIRTUAL_WEIGHT": "5"}, want: Backend{Weight: 5}, ok: true},
+		{config: map[string]string{"VIRTUAL_BACKUP": "true", "VIRTUAL_DOWN": "1"}, want: Backend{Backup: true, Down: true}, ok: true},
+		{config: map[string]string{"VIRTUAL_BACKUP": "false"}, want: Backend{}, ok: true},
+		{config: map[string]string{"VIRTUAL_WEIGHT": "0"}, ok: false},
+		{config: map[string]string{"VIRTUAL_WEIGHT": "heavy"}, ok: false},
+		{config: map[string]string{"VIRTUAL_DOWN": "maybe"}, ok: false},
+	}
+	for _, v := range tt {
+		b := Backend{Config: v.config}
+		err := upstreamOptions(&b)
+		if ok := err == nil; ok != v.ok {
+			t.Fatalf("wanted ok for %v: %v, got err: %v", v.config, v.ok, err)
+		}
+		if !v.ok {
+			continue
+		}
+		if b.Weight != v.want.Weight || b.Backup != v.want.Backup || b.Down != v.want.Down {
+			t.Fatalf("wanted options for %v: %#v, got: %#v", v.config, v.want, b)
+		}
+	}
+}
+
+func TestProtos(t *testing.T) {
+	tt := []struct {
+		proto string
+		want  string
+		ok    bool
+	}{
+		{proto: "", want: "", ok: true},
+		{proto: "HTTP", want: "", ok: true},
+		{proto: "https", want: "https", ok: true},
+		{proto: "gRPC", want: "grpc", ok: true},
+		{proto: "fastcgi", want: "fastcgi", ok: true},
+		{proto: "uwsgi", want: "uwsgi", ok: true},
+		{proto: "tcp", want: "tcp", ok: true},
+		{proto: "smtp", ok: false},
+	}
+	for _, v := range tt {
+		got, ok := findProto(map[string]string{"VIRTUAL_PROTO": v.proto})
+		if ok != v.ok || got != v.want {
+			t.Fatalf("wanted proto for %q: %q (%v), got: %q (%v)", v.proto, v.want, v.ok, got, ok)
+		}
+	}
+}
+
+func TestIsHealthy(t *testing.T) {
+	tt := []struct {
+		status string
+		m      map[string]string
+		want   bool
+		ok     bool
+	}{
+		{status: "", m: map[string]string{}, want: true, ok: true},
+		{status: "none", m: map[string]string{}, want: true, ok: true},
+		{status: "healthy", m: map[string]string{}, want: true, ok: true},
+		{status: "starting", m: map[string]string{}, want: false, ok: true},
+		{status: "unhealthy", m: map[string]string{}, want: false, ok: true},
+		{status: "starting", m: map[string]string{"VIRTUAL_HEALTHCHECK": "starting"}, want: true, ok: true},
+		{status: "unhealthy", m: map[string]string{"VIRTUAL_HEALTHCHECK": "starting"}, want: false, ok: true},
+		{status: "unhealthy", m: map[string]string{"VIRTUAL_HEALTHCHECK": "ignore"}, want: true, ok: true},
+		{status: "healthy", m: map[string]string{"VIRTUAL_HEALTHCHECK": "ignored"}, want: false, ok: false},
+	}
+	for _, v := range tt {
+		got, ok := isHealthy(v.status, v.m)
+		if got != v.want || ok != v.ok {
+			t.Fatalf("wanted healthy with status %q and %v: %v, %v, got: %v, %v", v.status, v.m, v.want, v.ok, got, ok)
+		}
+	}
+}
+
+func TestHostModeMapping(t *testing.T) {
+	a, aList := fakeContainer("a", "www.google.com", "172.17.0.2")
+	aList.Networks.Networks = map[string]docker.ContainerNetwork{"bridge": {IPAddress: "172.17.0.2"}}
+	b, bList := fakeContainer("b", "www.yahoo.com", "10.0.1.3")
+	b.NetworkSettings.Ports["80/tcp"] = []docker.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}}
+	client, done := fakeDocker(t, 0, a, b)
+	defer done()
+
+	host := self{Networks: defaultHostNetworks, Address: "127.0.0.1"}
+	report, err := mapContainers(client, host, DockerConfig{}, []docker.APIContainers{aList, bList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+
+	want := []Mapping{
+		{Address: "172.17.0.2", Port: 80, Network: "bridge"},
+		{Address: "127.0.0.1", Port: 8080, Network: "public"},
+	}
+	if len(report.Sites) != len(want) {
+		t.Fatalf("wanted %d sites, got: %#v", len(want), report.Sites)
+	}
+	for i, v := range want {
+		if got := report.Sites[i].Backends[0].Contact; got != v {
+			t.Fatalf("wanted mapping: %#v, got: %#v", v, got)
+		}
+	}
+}
+
 func TestNetworkMapping(t *testing.T) {
 	tt := []struct {
 		my    []string
@@ -80,7 +318,7 @@ func TestNetworkMapping(t *testing.T) {
 		ports []docker.APIPort
 		port  int64
 		want  *Mapping
-		ok    bool
+		err   error
 	}{
 		{
 			my:    []string{},
@@ -88,7 +326,7 @@ func TestNetworkMapping(t *testing.T) {
 			ports: []docker.APIPort{{PrivatePort: 9001, PublicPort: 80, IP: "4.2.2.1"}},
 			port:  80,
 			want:  &Mapping{Address: "4.2.2.1", Port: 80, Network: "public"},
-			ok:    true,
+			err:   nil,
 		},
 		{
 			my:    []string{},
@@ -96,7 +334,7 @@ func TestNetworkMapping(t *testing.T) {
 			ports: []docker.APIPort{{PrivatePort: 9001, PublicPort: 80, IP: "4.2.2.1"}, {PrivatePort: 9002, PublicPort: 81, IP: "4.2.2.1"}},
 			port:  81,
 			want:  &Mapping{Address: "4.2.2.1", Port: 81, Network: "public"},
-			ok:    true,
+			err:   nil,
 		},
 		{
 			my:    []string{},
@@ -104,7 +342,7 @@ func TestNetworkMapping(t *testing.T) {
 			ports: []docker.APIPort{{PrivatePort: 9001, PublicPort: 80, IP: "4.2.2.1"}, {PrivatePort: 9002, PublicPort: 81, IP: "4.2.2.1"}},
 			port:  82,
 			want:  nil,
-			ok:    false,
+			err:   ErrNoPort,
 		},
 		{
 			my:    []string{"overlay-net"},
@@ -112,7 +350,23 @@ func TestNetworkMapping(t *testing.T) {
 			ports: []docker.APIPort{{PrivatePort: 9001}},
 			port:  0,
 			want:  &Mapping{Address: "10.0.1.2", Port: 9001, Network: "overlay-net"},
-			ok:    true,
+			err:   nil,
+		},
+		{
+			my:    []string{},
+			you:   docker.NetworkList{Networks: map[string]docker.ContainerNetwork{}},
+			ports: []docker.APIPort{{PrivatePort: 9001, PublicPort: 80, IP: "4.2.2.1"}},
+			port:  9000,
+			want:  nil,
+			err:   ErrNoPort,
+		},
+		{
+			my:    []string{"overlay-net"},
+			you:   docker.NetworkList{Networks: map[string]docker.ContainerNetwork{"overlay-net": {IPAddress: "10.0.1.2"}}},
+			ports: []docker.APIPort{{PrivatePort: 9001}},
+			port:  9001,
+			want:  &Mapping{Address: "10.0.1.2", Port: 9001, Network: "overlay-net"},
+			err:   nil,
 		},
 		{
 			my:    []string{},
@@ -120,7 +374,7 @@ func TestNetworkMapping(t *testing.T) {
 			ports: []docker.APIPort{},
 			port:  0,
 			want:  nil,
-			ok:    false,
+			err:   ErrNoPort,
 		},
 		{
 			my:    []string{},
@@ -128,16 +382,523 @@ func TestNetworkMapping(t *testing.T) {
 			ports: []docker.APIPort{{PrivatePort: 9001, PublicPort: 80, IP: "4.2.2.1"}, {PrivatePort: 9002, PublicPort: 81, IP: "4.2.2.1"}},
 			port:  0,
 			want:  nil,
-			ok:    false,
+			err:   ErrNoPort,
+		},
+		{
+			my:    []string{"overlay-net"},
+			you:   docker.NetworkList{Networks: map[string]docker.ContainerNetwork{"other-net": {IPAddress: "10.0.2.2"}}},
+			ports: []docker.APIPort{{PrivatePort: 9001}},
+			port:  0,
+			want:  nil,
+			err:   ErrNoRoute,
 		},
 	}
 	for _, v := range tt {
-		mapping, ok := findMapping(v.my, v.you, v.ports, v.port)
-		if ok != v.ok {
-			t.Errorf("wanted ok: %v, got: %v", v.ok, ok)
+		mapping, err := findMapping(v.my, v.you, v.ports, v.port)
+		if err != v.err {
+			t.Errorf("wanted err: %v, got: %v", v.err, err)
 		}
 		if !reflect.DeepEqual(mapping, v.want) {
 			t.Fatalf("wanted mapping: %#v, got: %#v", v.want, mapping)
 		}
 	}
 }
+
+func TestGroupSites(t *testing.T) {
+	a := Backend{ID: "a", Contact: Mapping{Address: "10.0.1.2", Port: 80}, Config: map[string]string{"A": "a"}}
+	b := Backend{ID: "b", Contact: Mapping{Address: "10.0.1.3", Port: 80}, Config: map[string]string{"B": "b"}}
+	c := Backend{ID: "c", Contact: Mapping{Address: "10.0.1.4", Port: 80}, Config: map[string]string{"C": "c"}}
+	strip := Backend{ID: "d", Contact: Mapping{Address: "10.0.1.5", Port: 80}, Config: map[string]string{"VIRTUAL_PATH_STRIP": "true"}}
+
+	tt := []struct {
+		sites []Site
+		want  []Site
+	}{
+		{
+			sites: []Site{},
+			want:  []Site{},
+		},
+		{
+			sites: []Site{
+				{Host: "www.google.com", Path: "/", Backends: []Backend{b}},
+				{Host: "www.google.com", Path: "/", Backends: []Backend{a}},
+			},
+			want: []Site{
+				{ID: "www.google.com", Host: "www.google.com", Path: "/", Backends: []Backend{a, b}, Config: a.Config},
+			},
+		},
+		{
+			sites: []Site{
+				{Host: "yahoo.com", Path: "/", Backends: []Backend{c}},
+				{Host: "google.com", Path: "/", Backends: []Backend{b}},
+				{Host: "yahoo.com", Path: "/", Backends: []Backend{a}},
+			},
+			want: []Site{
+				{ID: "google.com", Host: "google.com", Path: "/", Backends: []Backend{b}, Config: b.Config},
+				{ID: "yahoo.com", Host: "yahoo.com", Path: "/", Backends: []Backend{a, c}, Config: a.Config},
+			},
+		},
+		{
+			sites: []Site{{Host: "*.google.com", Path: "/", Backends: []Backend{a}}},
+			want:  []Site{{ID: "_.google.com-5063166b65b58199", Host: "*.google.com", Path: "/", Backends: []Backend{a}, Config: a.Config}},
+		},
+		{
+			sites: []Site{
+				{Host: "google.com", Path: "/api", Backends: []Backend{strip}},
+				{Host: "google.com", Path: "/", Backends: []Backend{b}},
+				{Host: "google.com", Path: "/api", Backends: []Backend{c}},
+			},
+			want: []Site{
+				{ID: "google.com", Host: "google.com", Path: "/", Backends: []Backend{b}, Config: b.Config},
+				{ID: "google.com_api-6ae64ab6a4adf670", Host: "google.com", Path: "/api", Backends: []Backend{c, strip}, Config: c.Config},
+			},
+		},
+		{
+			sites: []Site{
+				{Host: "google.com", Path: "/api", Backends: []Backend{strip}},
+			},
+			want: []Site{
+				{ID: "google.com_api-6ae64ab6a4adf670", Host: "google.com", Path: "/api", StripPath: true, Backends: []Backend{strip}, Config: strip.Config},
+			},
+		},
+		{
+			sites: []Site{
+				{Host: "a.com", Path: "/a_b", Backends: []Backend{a}},
+				{Host: "a.com", Path: "/a/b", Backends: []Backend{b}},
+			},
+			want: []Site{
+				{ID: "a.com_a_b-ea10bb0a94a4820b", Host: "a.com", Path: "/a/b", Backends: []Backend{b}, Config: b.Config},
+				{ID: "a.com_a_b-2d3988d60720ec4f", Host: "a.com", Path: "/a_b", Backends: []Backend{a}, Config: a.Config},
+			},
+		},
+	}
+	for _, v := range tt {
+		got := groupSites(v.sites)
+		if !reflect.DeepEqual(v.want, got) {
+			t.Fatalf("wanted sites: %#v, got: %#v", v.want, got)
+		}
+	}
+}
+
+func TestMapContainersSkipsFailures(t *testing.T) {
+	a, aList := fakeContainer("a", "www.google.com", "10.0.1.2")
+	_, goneList := fakeContainer("gone", "www.google.com", "10.0.1.3")
+	client, done := fakeDocker(t, 0, a)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{}, []docker.APIContainers{goneList, aList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+
+	if len(report.Failures) != 1 || report.Failures[0].ID != "gone" {
+		t.Fatalf("wanted a single failure for the missing container, got: %#v", report.Failures)
+	}
+
+	want := []Site{{
+		ID:   "www.google.com",
+		Host: "www.google.com",
+		Path: "/",
+		Backends: []Backend{{
+			ID:       "a",
+			Names:    []string{"/a"},
+			Owner:    "container:a",
+			Created:  time.Unix(0, 0),
+			Networks: []string{"overlay-net"},
+			Contact:  Mapping{Address: "10.0.1.2", Port: 80, Network: "overlay-net"},
+			Env:      map[string]string{"VIRTUAL_HOST": "www.google.com"},
+			Config:   map[string]string{"VIRTUAL_HOST": "www.google.com"},
+		}},
+		Config: map[string]string{"VIRTUAL_HOST": "www.google.com"},
+	}}
+	if !reflect.DeepEqual(want, report.Sites) {
+		t.Fatalf("wanted sites: %#v, got: %#v", want, report.Sites)
+	}
+}
+
+func TestMapContainersRejections(t *testing.T) {
+	noHost, noHostList := fakeContainer("nohost", "www.google.com", "10.0.1.2")
+	noHost.Config.Env = nil
+	badEnv, badEnvList := fakeContainer("badenv", "www.google.com", "10.0.1.3")
+	badEnv.Config.Env = append(badEnv.Config.Env, "BARE")
+	unhealthy, unhealthyList := fakeContainer("unhealthy", "www.google.com", "10.0.1.4")
+	unhealthy.State.Health.Status = "unhealthy"
+	twoPorts, twoPortsList := fakeContainer("twoports", "www.google.com", "10.0.1.5")
+	twoPorts.NetworkSettings.Ports["81/tcp"] = nil
+	noRoute, noRouteList := fakeContainer("noroute", "www.google.com", "10.0.1.6")
+	noRouteList.Networks.Networks = map[string]docker.ContainerNetwork{"other-net": {IPAddress: "10.0.2.6"}}
+	badHost, badHostList := fakeContainer("badhost", "bad_host.google.com", "10.0.1.7")
+	badWeight, badWeightList := fakeContainer("badweight", "www.google.com", "10.0.1.8")
+	badWeight.Config.Env = append(badWeight.Config.Env, "VIRTUAL_WEIGHT=-1")
+	badProto, badProtoList := fakeContainer("badproto", "www.google.com", "10.0.1.9")
+	badProto.Config.Env = append(badProto.Config.Env, "VIRTUAL_PROTO=smtp")
+	badListen, badListenList := fakeContainer("badlisten", "www.google.com", "10.0.1.10")
+	badListen.Config.Env = append(badListen.Config.Env, "VIRTUAL_PROTO=tcp", "VIRTUAL_LISTEN=70000")
+	badStrip, badStripList := fakeContainer("badstrip", "www.google.com", "10.0.1.11")
+	badStrip.Config.Env = append(badStrip.Config.Env, "VIRTUAL_PROTO=grpc", "VIRTUAL_PATH=/api", "VIRTUAL_PATH_STRIP=true")
+	badHealth, badHealthList := fakeContainer("badhealth", "www.google.com", "10.0.1.12")
+	badHealth.Config.Env = append(badHealth.Config.Env, "VIRTUAL_HEALTHCHECK=ignored")
+	client, done := fakeDocker(t, 0, noHost, badEnv, unhealthy, twoPorts, noRoute, badHost, badWeight, badProto, badListen, badStrip, badHealth)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{Streams: true}, []docker.APIContainers{noHostList, badEnvList, unhealthyList, twoPortsList, noRouteList, badHostList, badWeightList, badProtoList, badListenList, badStripList, badHealthList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Sites) != 0 {
+		t.Fatalf("wanted no sites, got: %#v", report.Sites)
+	}
+
+	want := []struct {
+		id     string
+		host   string
+		reason Reason
+	}{
+		{id: "nohost", reason: ReasonNoHost},
+		{id: "badenv", reason: ReasonInvalidEnv},
+		{id: "unhealthy", reason: ReasonUnhealthy},
+		{id: "twoports", host: "www.google.com", reason: ReasonNoPort},
+		{id: "noroute", host: "www.google.com", reason: ReasonNoRoute},
+		{id: "badhost", host: "bad_host.google.com", reason: ReasonInvalidHost},
+		{id: "badweight", reason: ReasonInvalidUpstream},
+		{id: "badproto", reason: ReasonInvalidProto},
+		{id: "badlisten", reason: ReasonInvalidListen},
+		{id: "badstrip", reason: ReasonInvalidPath},
+		{id: "badhealth", reason: ReasonInvalidHealthcheck},
+	}
+	if len(report.Rejected) != len(want) {
+		t.Fatalf("wanted %d rejections, got: %#v", len(want), report.Rejected)
+	}
+	for i, v := range want {
+		got := report.Rejected[i]
+		if got.ID != v.id || got.Host != v.host || got.Reason != v.reason {
+			t.Fatalf("wanted rejection of %s for %q with %s, got: %#v", v.id, v.host, v.reason, got)
+		}
+	}
+}
+
+func TestMapContainersStreams(t *testing.T) {
+	a, aList := fakeContainer("a", "", "10.0.1.2")
+	a.Config.Env = []string{"VIRTUAL_PROTO=TCP", "VIRTUAL_LISTEN=5432", "VIRTUAL_PORT=5432"}
+	a.NetworkSettings.Ports = map[docker.Port][]docker.PortBinding{"5432/tcp": nil}
+	b, bList := fakeContainer("b", "", "10.0.1.3")
+	b.Config.Env = []string{"VIRTUAL_PROTO=tcp", "VIRTUAL_LISTEN=5432", "VIRTUAL_PORT=5432"}
+	b.NetworkSettings.Ports = map[docker.Port][]docker.PortBinding{"5432/tcp": nil}
+	c, cList := fakeContainer("c", "", "10.0.1.4")
+	c.Config.Env = []string{"VIRTUAL_PROTO=udp", "VIRTUAL_LISTEN=53", "VIRTUAL_PORT=53"}
+	c.NetworkSettings.Ports = map[docker.Port][]docker.PortBinding{"53/udp": nil}
+	web, webList := fakeContainer("web", "www.google.com", "10.0.1.5")
+	client, done := fakeDocker(t, 0, a, b, c, web)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{Streams: true}, []docker.APIContainers{aList, bList, cList, webList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 0 {
+		t.Fatalf("wanted no rejections, got: %#v", report.Rejected)
+	}
+
+	type site struct {
+		ID, Host, Proto string
+		Listen          int64
+		Backends        int
+	}
+	var got []site
+	for _, v := range report.Sites {
+		got = append(got, site{ID: v.ID, Host: v.Host, Proto: v.Proto, Listen: v.Listen, Backends: len(v.Backends)})
+	}
+	want := []site{
+		{ID: "udp_53", Proto: "udp", Listen: 53, Backends: 1},
+		{ID: "tcp_5432", Proto: "tcp", Listen: 5432, Backends: 2},
+		{ID: "www.google.com", Host: "www.google.com", Backends: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted sites: %#v, got: %#v", want, got)
+	}
+
+	// without a stream config, streams are rejected rather than dropped
+	report, err = mapContainers(client, me, DockerConfig{}, []docker.APIContainers{aList, bList, cList, webList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 3 || report.Rejected[0].Reason != ReasonInvalidProto {
+		t.Fatalf("wanted every stream to be rejected, got: %#v", report.Rejected)
+	}
+	if len(report.Sites) != 1 || report.Sites[0].ID != "www.google.com" {
+		t.Fatalf("wanted only the HTTP site, got: %#v", report.Sites)
+	}
+}
+
+func TestMapContainersStreamPorts(t *testing.T) {
+	policy := &HostPolicy{Rules: []HostRule{{Ports: []int64{5432}, Networks: []string{"db-net"}}}}
+	stream := func(id, ip, listen string) (*docker.Container, docker.APIContainers) {
+		c, list := fakeContainer(id, "", ip)
+		c.Config.Env = []string{"VIRTUAL_PROTO=tcp", "VIRTUAL_LISTEN=" + listen}
+		return c, list
+	}
+	reserved, reservedList := stream("reserved", "10.0.1.2", "80")
+	tenant, tenantList := stream("tenant", "10.0.1.3", "5432")
+	redis, redisList := stream("redis", "10.0.1.4", "6379")
+	client, done := fakeDocker(t, 0, reserved, tenant, redis)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{HostPolicy: policy, Streams: true}, []docker.APIContainers{reservedList, tenantList, redisList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	want := []struct {
+		id     string
+		reason Reason
+	}{
+		{id: "reserved", reason: ReasonInvalidListen},
+		{id: "tenant", reason: ReasonUnauthorizedHost},
+	}
+	if len(report.Rejected) != len(want) {
+		t.Fatalf("wanted %d rejections, got: %#v", len(want), report.Rejected)
+	}
+	for i, v := range want {
+		if got := report.Rejected[i]; got.ID != v.id || got.Reason != v.reason {
+			t.Fatalf("wanted rejection of %s with %s, got: %#v", v.id, v.reason, got)
+		}
+	}
+	if len(report.Sites) != 1 || report.Sites[0].ID != "tcp_6379" {
+		t.Fatalf("wanted only the unlisted port to be proxied, got: %#v", report.Sites)
+	}
+}
+
+func TestMapContainersNormalizesHosts(t *testing.T) {
+	c, list := fakeContainer("abc", " WWW.Google.com, www.google.com.,,bücher.google.com", "10.0.1.2")
+	client, done := fakeDocker(t, 0, c)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{}, []docker.APIContainers{list})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	var hosts []string
+	for _, v := range report.Sites {
+		hosts = append(hosts, v.Host)
+	}
+	want := []string{"www.google.com", "xn--bcher-kva.google.com"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("wanted hosts: %#v, got: %#v", want, hosts)
+	}
+}
+
+func TestMapContainersDuplicateHostPorts(t *testing.T) {
+	c, list := fakeContainer("abc", "www.google.com:8080,WWW.google.com:9090,www.google.com:8080", "10.0.1.2")
+	c.NetworkSettings.Ports = map[docker.Port][]docker.PortBinding{"8080/tcp": nil, "9090/tcp": nil}
+	client, done := fakeDocker(t, 0, c)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{}, []docker.APIContainers{list})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Sites) != 1 || report.Sites[0].Backends[0].Contact.Port != 8080 {
+		t.Fatalf("wanted www.google.com on port 8080, got: %#v", report.Sites)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].Host != "www.google.com" || report.Rejected[0].Reason != ReasonInvalidHost {
+		t.Fatalf("wanted the second port of www.google.com rejected, got: %#v", report.Rejected)
+	}
+}
+
+func TestMapContainersHostPolicy(t *testing.T) {
+	policy := &HostPolicy{Rules: []HostRule{{Hosts: []string{"*.google.com"}, Projects: []string{"search"}}}}
+	tenant, tenantList := fakeContainer("tenant", "www.google.com", "10.0.1.2")
+	owner, ownerList := fakeContainer("owner", "www.google.com", "10.0.1.3")
+	owner.Config.Labels = map[string]string{composeProjectLabel: "search"}
+	other, otherList := fakeContainer("other", "www.yahoo.com", "10.0.1.4")
+	client, done := fakeDocker(t, 0, tenant, owner, other)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{HostPolicy: policy}, []docker.APIContainers{tenantList, ownerList, otherList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].ID != "tenant" || report.Rejected[0].Reason != ReasonUnauthorizedHost {
+		t.Fatalf("wanted the tenant to be rejected, got: %#v", report.Rejected)
+	}
+	if len(report.Sites) != 2 || len(report.Sites[0].Backends) != 1 || report.Sites[0].Backends[0].ID != "owner" {
+		t.Fatalf("wanted the owner and the unlisted host to be proxied, got: %#v", report.Sites)
+	}
+}
+
+func TestMapContainersDefault(t *testing.T) {
+	policy := &HostPolicy{Rules: []HostRule{{Hosts: []string{"www.google.com"}, Networks: []string{"overlay-net"}}}}
+	allowed, allowedList := fakeContainer("allowed", "www.google.com", "10.0.1.2")
+	allowed.Config.Env = append(allowed.Config.Env, "VIRTUAL_DEFAULT=true")
+	tenant, tenantList := fakeContainer("tenant", "www.yahoo.com", "10.0.1.3")
+	tenant.Config.Env = append(tenant.Config.Env, "VIRTUAL_DEFAULT=true")
+	client, done := fakeDocker(t, 0, allowed, tenant)
+	defer done()
+
+	// the tenant matches the only rule, so both may be the default
+	report, err := mapContainers(client, me, DockerConfig{HostPolicy: policy}, []docker.APIContainers{allowedList, tenantList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 0 || !report.Sites[0].Backends[0].Default || !report.Sites[1].Backends[0].Default {
+		t.Fatalf("wanted both containers to be allowed to be the default, got: %#v", report)
+	}
+
+	policy.Rules = append(policy.Rules, HostRule{Hosts: []string{"shop.google.com"}, Labels: map[string]string{"team": "shop"}})
+	report, err = mapContainers(client, me, DockerConfig{HostPolicy: policy}, []docker.APIContainers{allowedList, tenantList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 2 || report.Rejected[0].Reason != ReasonUnauthorizedHost {
+		t.Fatalf("wanted both default claims to be rejected, got: %#v", report.Rejected)
+	}
+	if len(report.Sites) != 2 || report.Sites[0].Backends[0].Default || report.Sites[1].Backends[0].Default {
+		t.Fatalf("wanted both sites proxied without being the default, got: %#v", report.Sites)
+	}
+
+	// with a default host set, the setting is ignored
+	report, err = mapContainers(client, me, DockerConfig{HostPolicy: policy, DefaultHost: "www.google.com"}, []docker.APIContainers{allowedList, tenantList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 0 || report.Sites[0].Backends[0].Default {
+		t.Fatalf("wanted the setting to be ignored, got: %#v", report)
+	}
+}
+
+func TestMapContainersSelector(t *testing.T) {
+	public, publicList := fakeContainer("public", "www.google.com", "10.0.1.2")
+	publicList.Labels = map[string]string{"proxy": "public"}
+	internal, internalList := fakeContainer("internal", "intranet.google.com", "10.0.1.3")
+	internalList.Labels = map[string]string{"proxy": "internal"}
+	client, done := fakeDocker(t, 0, public, internal)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{Labels: []string{"proxy=public"}}, []docker.APIContainers{publicList, internalList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 0 || len(report.Failures) != 0 {
+		t.Fatalf("wanted containers for other proxies to be skipped silently, got: %#v", report)
+	}
+	if len(report.Sites) != 1 || report.Sites[0].Host != "www.google.com" {
+		t.Fatalf("wanted only the selected container to be proxied, got: %#v", report.Sites)
+	}
+}
+
+func TestMapContainersCompose(t *testing.T) {
+	labels := map[string]string{composeProjectLabel: "shop", composeServiceLabel: "web"}
+	a, aList := fakeContainer("a", "www.google.com", "10.0.1.2")
+	a.Config.Labels = labels
+	b, bList := fakeContainer("b", "www.google.com", "10.0.1.3")
+	b.Config.Labels = labels
+	c, cList := fakeContainer("c", "www.yahoo.com", "10.0.1.4")
+	client, done := fakeDocker(t, 0, a, b, c)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{}, []docker.APIContainers{aList, bList, cList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Sites) != 2 {
+		t.Fatalf("wanted 2 sites, got: %#v", report.Sites)
+	}
+	shop := report.Sites[0]
+	if shop.Project != "shop" || shop.Service != "web" || len(shop.Backends) != 2 {
+		t.Fatalf("wanted both replicas in the shop/web site, got: %#v", shop)
+	}
+	for _, v := range shop.Backends {
+		if v.Project != "shop" || v.Service != "web" {
+			t.Fatalf("wanted backend in shop/web, got: %#v", v)
+		}
+	}
+	if other := report.Sites[1]; other.Project != "" || other.Service != "" {
+		t.Fatalf("wanted no project for a plain container, got: %#v", other)
+	}
+}
+
+func TestFillSiteMixedProjects(t *testing.T) {
+	s := Site{Path: "/", Backends: []Backend{
+		{ID: "a", Project: "shop", Service: "web"},
+		{ID: "b", Project: "shop", Service: "api"},
+	}}
+	fillSite(&s)
+	if s.Project != "" || s.Service != "" {
+		t.Fatalf("wanted no project for a site with mixed backends, got: %q/%q", s.Project, s.Service)
+	}
+}
+
+func TestMapContainersLenientEnv(t *testing.T) {
+	badEnv, badEnvList := fakeContainer("badenv", "www.google.com", "10.0.1.3")
+	badEnv.Config.Env = append(badEnv.Config.Env, "BARE", "VIRTUAL_PORT=80")
+	client, done := fakeDocker(t, 0, badEnv)
+	defer done()
+
+	report, err := mapContainers(client, me, DockerConfig{LenientEnv: true}, []docker.APIContainers{badEnvList})
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	if len(report.Rejected) != 0 {
+		t.Fatalf("wanted no rejections, got: %#v", report.Rejected)
+	}
+	if len(report.Sites) != 1 {
+		t.Fatalf("wanted 1 site, got: %#v", report.Sites)
+	}
+	site := report.Sites[0]
+	if got := site.Backends[0].Config[portKey]; got != "80" {
+		t.Fatalf("wanted the valid lines to be kept, got port: %q", got)
+	}
+	want := []string{`badenv: ignored environment line without "=": "BARE"`}
+	if !reflect.DeepEqual(site.Warnings, want) {
+		t.Fatalf("wanted warnings: %#v, got: %#v", want, site.Warnings)
+	}
+}
+
+func TestMapContainersOrder(t *testing.T) {
+	var containers []*docker.Container
+	var list []docker.APIContainers
+	for i := 0; i < 50; i++ {
+		id := strconv.Itoa(i)
+		c, l := fakeContainer(id, "www.google.com", "10.0.1."+id)
+		containers = append(containers, c)
+		list = append(list, l)
+	}
+	client, done := fakeDocker(t, 0, containers...)
+	defer done()
+
+	first, err := mapContainers(client, me, DockerConfig{Workers: 1}, list)
+	if err != nil {
+		t.Fatalf("wanted no error mapping containers, got: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		got, err := mapContainers(client, me, DockerConfig{Workers: 16}, list)
+		if err != nil {
+			t.Fatalf("wanted no error mapping containers, got: %s", err)
+		}
+		if !reflect.DeepEqual(first, got) {
+			t.Fatalf("wanted parallel inspection to match sequential, got: %#v", got)
+		}
+	}
+}
+
+func BenchmarkMapContainers(b *testing.B) {
+	var containers []*docker.Container
+	var list []docker.APIContainers
+	for i := 0; i < 2000; i++ {
+		id := strconv.Itoa(i)
+		c, l := fakeContainer(id, "host"+id+".google.com", "10.0."+strconv.Itoa(i/250)+"."+strconv.Itoa(i%250))
+		containers = append(containers, c)
+		list = append(list, l)
+	}
+	client, done := fakeDocker(b, time.Millisecond, containers...)
+	defer done()
+
+	for _, workers := range []int{1, 8, 32} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := mapContainers(client, me, DockerConfig{Workers: workers}, list); err != nil {
+					b.Fatalf("unable to map containers: %s", err)
+				}
+			}
+		})
+	}
+}
@@ -2,11 +2,27 @@ package nginx
 
 var m = map[string]string{
 	"NGINX_CLIENT_MAX_BODY_SIZE": "client_max_body_size",
+	"NGINX_ROOT":                 "root",
+}
+
+// streamDirectives are the directives that can be set for tcp and udp
+// sites, which only accept a handful of the directives of HTTP sites
+var streamDirectives = map[string]string{
+	"NGINX_PROXY_TIMEOUT":         "proxy_timeout",
+	"NGINX_PROXY_CONNECT_TIMEOUT": "proxy_connect_timeout",
 }
 
 func envToDirectives(env map[string]string) map[string]string {
+	return directives(m, env)
+}
+
+func envToStreamDirectives(env map[string]string) map[string]string {
+	return directives(streamDirectives, env)
+}
+
+func directives(names, env map[string]string) map[string]string {
 	out := map[string]string{}
-	for k, v := range m {
+	for k, v := range names {
 		if val, ok := env[k]; ok {
 			out[v] = val
 		}
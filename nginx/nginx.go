@@ -11,6 +11,7 @@ import (
 	"sync"
 	"text/template"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/nickvanw/docker-proxy"
 )
 
@@ -20,9 +21,17 @@ const (
 	httpAuthPass = "AUTH_PASS"
 )
 
-// Server represents an nginx server to configure
+// Server represents an nginx server to configure. Requests for unknown
+// hosts go to the host of the Default sites, or are answered with a 503.
 type Server struct {
 	Syslog string
+	// StreamConfig is where tcp and udp sites are written as a stream
+	// block, which must be included outside of the http block. They are
+	// left out if it isn't set.
+	StreamConfig string
+	// FallbackPage is the body of the 503 for unknown hosts, which must be
+	// an absolute path
+	FallbackPage string
 
 	ssl      string
 	htpasswd string
@@ -33,8 +42,10 @@ type Server struct {
 	upstreamTpl *template.Template
 	noSSLTpl    *template.Template
 	sslTpl      *template.Template
+	streamTpl   *template.Template
 
-	last []byte
+	last       []byte
+	lastStream []byte
 
 	sync.Mutex
 }
@@ -52,8 +63,13 @@ func New(ssl, config, reload, htpasswd string) (*Server, error) {
 	s.upstreamTpl = template.Must(template.New("nginxUpstream").Parse(nginxUpstream))
 	s.noSSLTpl = template.Must(template.New("nginxNoSSL").Parse(nginxNoSSL))
 	s.sslTpl = template.Must(template.New("nginxWithSSL").Parse(nginxWithSSL))
+	s.streamTpl = template.Must(template.New("nginxStream").Parse(nginxStream))
 	template.Must(s.noSSLTpl.Parse(nginxOptions))
 	template.Must(s.sslTpl.Parse(nginxOptions))
+	template.Must(s.noSSLTpl.Parse(nginxLocation))
+	template.Must(s.sslTpl.Parse(nginxLocation))
+	template.Must(s.streamTpl.Parse(nginxOptions))
+	template.Must(s.streamTpl.New("nginxUpstream").Parse(nginxUpstream))
 
 	return s, nil
 }
@@ -63,70 +79,208 @@ func (s *Server) Name() string {
 	return "nginx"
 }
 
-// Update is called with a list of sites to update the nginx configuration
+// Update is called with a list of sites to update the nginx configuration.
+// nginx is reloaded once if either the HTTP or stream configuration changed.
 func (s *Server) Update(sites []dockerproxy.Site) error {
 	s.Lock()
 	defer s.Unlock()
 
+	var httpSites, streamSites []dockerproxy.Site
+	for _, v := range sites {
+		if isStream(v) {
+			streamSites = append(streamSites, v)
+		} else {
+			httpSites = append(httpSites, v)
+		}
+	}
+
+	// the hosts are rendered first, as the catch-all servers depend on
+	// which of them were written
+	hosts := groupHosts(httpSites)
+	def := defaultHost(hosts)
+	h := header{Syslog: s.Syslog}
+	var servers bytes.Buffer
+	for i, v := range hosts {
+		written, ssl, err := s.renderHost(&servers, v[0].Host, v, i == def)
+		if err != nil {
+			return err
+		}
+		h.AnySSL = h.AnySSL || ssl
+		if written && i == def {
+			h.DefaultHTTP, h.DefaultHTTPS = true, ssl
+		}
+	}
+	if !h.DefaultHTTP && s.FallbackPage != "" {
+		h.FallbackRoot, h.FallbackName = filepath.Split(s.FallbackPage)
+	}
+
 	var data bytes.Buffer
-	if err := s.headerTpl.Execute(&data, s); err != nil {
+	if err := s.headerTpl.Execute(&data, h); err != nil {
 		return err
 	}
 
-	for _, v := range sites {
-		if err := s.renderSite(&data, v); err != nil {
+	for _, v := range httpSites {
+		if err := s.upstreamTpl.Execute(&data, v); err != nil {
 			return err
 		}
 	}
+	data.Write(servers.Bytes())
 
+	var stream bytes.Buffer
+	if s.StreamConfig != "" {
+		if err := s.renderStream(&stream, streamSites); err != nil {
+			return err
+		}
+	}
+
+	changed := false
 	if !bytes.Equal(data.Bytes(), s.last) {
-		fi, err := os.Create(s.cfg)
-		if err != nil {
+		if err := writeConfig(s.cfg, data.Bytes()); err != nil {
 			return err
 		}
-		_, err = io.Copy(fi, &data)
-		if err != nil {
+		changed = true
+	}
+	if s.StreamConfig != "" && !bytes.Equal(stream.Bytes(), s.lastStream) {
+		if err := writeConfig(s.StreamConfig, stream.Bytes()); err != nil {
 			return err
 		}
+		changed = true
+	}
+	if changed {
 		if err := exec.Command(s.reload[0], s.reload[1:]...).Run(); err != nil {
 			return err
 		}
 		s.last = data.Bytes()
+		s.lastStream = stream.Bytes()
 	}
 	return nil
 }
 
-func (s *Server) renderSite(wr io.Writer, site dockerproxy.Site) error {
-	if err := s.upstreamTpl.Execute(wr, site); err != nil {
+func writeConfig(name string, data []byte) error {
+	fi, err := os.Create(name)
+	if err != nil {
 		return err
 	}
+	defer fi.Close()
+	_, err = fi.Write(data)
+	return err
+}
 
-	for _, h := range site.Hosts {
-		if err := s.renderHost(wr, h, site); err != nil {
-			return err
+// isStream reports whether a site is proxied as a tcp or udp stream
+func isStream(site dockerproxy.Site) bool {
+	return site.Proto == "tcp" || site.Proto == "udp"
+}
+
+type streamsite struct {
+	dockerproxy.Site
+	Directives map[string]string
+}
+
+// renderStream renders the stream block, with an upstream and server for
+// each of the stream sites
+func (s *Server) renderStream(wr io.Writer, sites []dockerproxy.Site) error {
+	d := make([]streamsite, 0, len(sites))
+	for _, v := range sites {
+		d = append(d, streamsite{Site: v, Directives: envToStreamDirectives(v.Config)})
+	}
+	return s.streamTpl.Execute(wr, d)
+}
+
+// header is the data for the header template. The catch-all servers are
+// only rendered for the ports the default host doesn't listen on, and the
+// HTTPS one only if some host listens on 443, as rejecting the handshake
+// needs a newer nginx than plain HTTP does.
+type header struct {
+	Syslog       string
+	AnySSL       bool
+	DefaultHTTP  bool
+	DefaultHTTPS bool
+	FallbackRoot string
+	FallbackName string
+}
+
+// defaultHost returns the index of the host requests for unknown hosts are
+// sent to, or -1 if there isn't one
+func defaultHost(hosts [][]dockerproxy.Site) int {
+	for i, v := range hosts {
+		if v[0].Default {
+			return i
 		}
 	}
-	return nil
+	return -1
+}
+
+// groupHosts collects the sites for each host, so that every path on a
+// host can be rendered into a single server block. Hosts are returned in
+// the order they are first seen.
+func groupHosts(sites []dockerproxy.Site) [][]dockerproxy.Site {
+	index := map[string]int{}
+	var out [][]dockerproxy.Site
+	for _, v := range sites {
+		i, ok := index[v.Host]
+		if !ok {
+			i = len(out)
+			index[v.Host] = i
+			out = append(out, nil)
+		}
+		out[i] = append(out[i], v)
+	}
+	return out
 }
 
 type dockersite struct {
 	Host      string
-	ID        string
+	Default   bool
 	SSLPrefix string
 	Config    map[string]string
+	Locations []location
+}
+
+type location struct {
+	Path  string
+	ID    string
+	URI   string
+	Proto string
 }
 
-func (s *Server) renderHost(wr io.Writer, host string, site dockerproxy.Site) error {
+// newLocation returns the location for a site. Anything other than the root
+// is matched with a trailing slash, so that nginx redirects the bare path,
+// and a stripped path is replaced by proxying to the root of the upstream.
+func newLocation(site dockerproxy.Site) location {
+	l := location{Path: site.Path, ID: site.ID, Proto: site.Proto}
+	if l.Path == "" {
+		l.Path = "/"
+	}
+	if l.Path != "/" {
+		l.Path += "/"
+	}
+	if site.StripPath {
+		l.URI = "/"
+	}
+	return l
+}
+
+// renderHost renders the server block for a host, with a location for each
+// of the sites. Configuration for the host is taken from the first site. The
+// default host is also the default server for the ports it listens on. As
+// nginx only accepts gRPC over HTTP/2, which is only served with HTTPS,
+// gRPC sites are left out of hosts without a certificate. It reports
+// whether a server block was written, and whether it serves HTTPS.
+func (s *Server) renderHost(wr io.Writer, host string, sites []dockerproxy.Site, def bool) (bool, bool, error) {
+	site := sites[0]
 	d := dockersite{
-		Host:   host,
-		ID:     site.ID,
-		Config: envToDirectives(site.Env),
+		Host:    host,
+		Default: def,
+		Config:  envToDirectives(site.Config),
+	}
+	for _, v := range sites {
+		d.Locations = append(d.Locations, newLocation(v))
 	}
 	var ok bool
 
 	passwd, err := s.httpAuthInfo(host, site)
 	if err != nil {
-		return err
+		return false, false, err
 	}
 	if passwd != "" {
 		d.Config["auth_basic"] = `"closed site"`
@@ -134,14 +288,35 @@ func (s *Server) renderHost(wr io.Writer, host string, site dockerproxy.Site) er
 	}
 	if d.SSLPrefix, ok = s.sslInfo(host, site); ok {
 		if err := s.sslTpl.Execute(wr, d); err != nil {
-			return err
+			return false, false, err
 		}
-	} else {
-		if err := s.noSSLTpl.Execute(wr, d); err != nil {
-			return err
+		return true, true, nil
+	}
+	d.Locations = plainLocations(host, d.Locations)
+	if len(d.Locations) == 0 {
+		return false, false, nil
+	}
+	if err := s.noSSLTpl.Execute(wr, d); err != nil {
+		return false, false, err
+	}
+	return true, false, nil
+}
+
+// plainLocations returns the locations that can be served without HTTPS,
+// warning about any that can't
+func plainLocations(host string, locations []location) []location {
+	var out []location
+	for _, v := range locations {
+		if v.Proto == "grpc" || v.Proto == "grpcs" {
+			log.WithFields(log.Fields{
+				"host": host,
+				"path": v.Path,
+			}).Warn("gRPC needs a certificate for the host, not proxying")
+			continue
 		}
+		out = append(out, v)
 	}
-	return nil
+	return out
 }
 
 func (s *Server) httpAuthInfo(host string, site dockerproxy.Site) (string, error) {
@@ -149,13 +324,13 @@ func (s *Server) httpAuthInfo(host string, site dockerproxy.Site) (string, error
 	var ok bool
 
 	out := bytes.NewBuffer(nil)
-	if user, ok = site.Env[httpAuthUser]; ok {
+	if user, ok = site.Config[httpAuthUser]; ok {
 		out.Write([]byte(user + ":"))
 	} else {
 		return "", nil
 	}
 
-	if pass, ok = site.Env[httpAuthPass]; ok {
+	if pass, ok = site.Config[httpAuthPass]; ok {
 		out.Write([]byte(pass))
 	} else {
 		return "", nil
@@ -172,7 +347,7 @@ func (s *Server) httpAuthInfo(host string, site dockerproxy.Site) (string, error
 
 func (s *Server) sslInfo(host string, site dockerproxy.Site) (string, bool) {
 	key := host
-	if name, ok := site.Env[sslNameEnv]; ok {
+	if name, ok := site.Config[sslNameEnv]; ok {
 		key = name
 	}
 	pfx := filepath.Join(s.ssl, key)
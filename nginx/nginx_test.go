@@ -18,8 +18,8 @@ func TestHostNoSSL(t *testing.T) {
 	}
 	host := "www.google.com"
 	site := dockerproxy.Site{
-		ID:  "my-id-here",
-		Env: map[string]string{},
+		ID:     "my-id-here",
+		Config: map[string]string{},
 	}
 	wantOutput := `
 server {
@@ -33,7 +33,7 @@ server {
 `
 
 	buf := bytes.NewBuffer(nil)
-	err = s.renderHost(buf, host, site)
+	_, _, err = s.renderHost(buf, host, []dockerproxy.Site{site}, false)
 	if err != nil {
 		t.Fatalf("wanted no error when laying down basic site template, got: %v", err)
 	}
@@ -50,8 +50,8 @@ func TestHostWithConfigNoSSL(t *testing.T) {
 	}
 	host := "www.google.com"
 	site := dockerproxy.Site{
-		ID:  "my-id-here",
-		Env: map[string]string{"NGINX_CLIENT_MAX_BODY_SIZE": "5m"},
+		ID:     "my-id-here",
+		Config: map[string]string{"NGINX_CLIENT_MAX_BODY_SIZE": "5m"},
 	}
 	wantOutput := `
 server {
@@ -66,7 +66,7 @@ server {
 `
 
 	buf := bytes.NewBuffer(nil)
-	err = s.renderHost(buf, host, site)
+	_, _, err = s.renderHost(buf, host, []dockerproxy.Site{site}, false)
 	if err != nil {
 		t.Fatalf("wanted no error when laying down basic site template, got: %v", err)
 	}
@@ -76,6 +76,158 @@ server {
 	}
 }
 
+func TestHostWithPathsNoSSL(t *testing.T) {
+	s, err := New(os.TempDir(), os.TempDir(), "/bin/true", "")
+	if err != nil {
+		t.Fatalf("unable to create new server: %s", err)
+	}
+	host := "www.google.com"
+	sites := []dockerproxy.Site{
+		{
+			ID:     "www.google.com",
+			Path:   "/",
+			Config: map[string]string{"NGINX_CLIENT_MAX_BODY_SIZE": "5m"},
+		},
+		{
+			ID:        "www.google.com_api",
+			Path:      "/api",
+			StripPath: true,
+			Config:    map[string]string{"NGINX_CLIENT_MAX_BODY_SIZE": "1m"},
+		},
+		{
+			ID:     "www.google.com_static",
+			Path:   "/static",
+			Config: map[string]string{},
+		},
+	}
+	wantOutput := `
+server {
+	server_name www.google.com;
+	listen 80;
+	client_max_body_size 5m;
+	 
+	location / {
+		proxy_pass http://www.google.com;
+	}
+	location /api/ {
+		proxy_pass http://www.google.com_api/;
+	}
+	location /static/ {
+		proxy_pass http://www.google.com_static;
+	}
+}
+`
+
+	buf := bytes.NewBuffer(nil)
+	_, _, err = s.renderHost(buf, host, sites, false)
+	if err != nil {
+		t.Fatalf("wanted no error when laying down site template with paths, got: %v", err)
+	}
+
+	if buf.String() != wantOutput {
+		t.Fatalf("want: %q\n got: %q\n", wantOutput, buf.String())
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	s, err := New(os.TempDir(), os.TempDir(), "/bin/true", "")
+	if err != nil {
+		t.Fatalf("unable to create new server: %s", err)
+	}
+	site := dockerproxy.Site{
+		ID:   "www.google.com",
+		Host: "www.google.com",
+		Backends: []dockerproxy.Backend{
+			{ID: "a", Contact: dockerproxy.Mapping{Address: "10.0.1.2", Port: 8080, Network: "overlay-net"}},
+			{ID: "b", Contact: dockerproxy.Mapping{Address: "4.2.2.1", Port: 80, Network: "public"}},
+			{ID: "c", Contact: dockerproxy.Mapping{Address: "10.0.1.3", Port: 8080, Network: "overlay-net"}, Weight: 5},
+			{ID: "d", Contact: dockerproxy.Mapping{Address: "10.0.1.4", Port: 8080, Network: "overlay-net"}, Backup: true},
+			{ID: "e", Contact: dockerproxy.Mapping{Address: "10.0.1.5", Port: 8080, Network: "overlay-net"}, Weight: 2, Down: true},
+		},
+	}
+	wantOutput := `
+upstream www.google.com {
+	## Container: a, Network: overlay-net
+	server 10.0.1.2:8080;
+	## Container: b, Network: public
+	server 4.2.2.1:80;
+	## Container: c, Network: overlay-net
+	server 10.0.1.3:8080 weight=5;
+	## Container: d, Network: overlay-net
+	server 10.0.1.4:8080 backup;
+	## Container: e, Network: overlay-net
+	server 10.0.1.5:8080 weight=2 down;
+}
+`
+
+	buf := bytes.NewBuffer(nil)
+	if err := s.upstreamTpl.Execute(buf, site); err != nil {
+		t.Fatalf("wanted no error when laying down upstream template, got: %v", err)
+	}
+
+	if buf.String() != wantOutput {
+		t.Fatalf("want: %q\n got: %q\n", wantOutput, buf.String())
+	}
+}
+
+func TestUpdatePerPortUpstreams(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nginxtest")
+	if err != nil {
+		t.Fatalf("unable to make temp dir: %q", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("unable to remove temp files: %s", err)
+		}
+	}()
+
+	cfg := filepath.Join(tmpDir, "nginx.conf")
+	s, err := New(tmpDir, cfg, "/bin/true", tmpDir)
+	if err != nil {
+		t.Fatalf("unable to create new server: %s", err)
+	}
+
+	// a single container serving two hosts from two ports
+	sites := []dockerproxy.Site{
+		{
+			ID:   "api.google.com",
+			Host: "api.google.com",
+			Path: "/",
+			Backends: []dockerproxy.Backend{
+				{ID: "a", Contact: dockerproxy.Mapping{Address: "10.0.1.2", Port: 9090, Network: "overlay-net"}},
+			},
+			Config: map[string]string{},
+		},
+		{
+			ID:   "ui.google.com",
+			Host: "ui.google.com",
+			Path: "/",
+			Backends: []dockerproxy.Backend{
+				{ID: "a", Contact: dockerproxy.Mapping{Address: "10.0.1.2", Port: 8080, Network: "overlay-net"}},
+			},
+			Config: map[string]string{},
+		},
+	}
+	if err := s.Update(sites); err != nil {
+		t.Fatalf("wanted no error updating config, got: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(cfg)
+	if err != nil {
+		t.Fatalf("unable to read written config: %s", err)
+	}
+	for _, want := range []string{
+		"upstream api.google.com {\n\t## Container: a, Network: overlay-net\n\tserver 10.0.1.2:9090;\n}",
+		"upstream ui.google.com {\n\t## Container: a, Network: overlay-net\n\tserver 10.0.1.2:8080;\n}",
+		"server_name api.google.com;",
+		"server_name ui.google.com;",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("wanted config to contain %q, got: %s", want, data)
+		}
+	}
+}
+
 func TestSSLInfo(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "ssltest")
 	if err != nil {
@@ -101,14 +253,14 @@ func TestSSLInfo(t *testing.T) {
 				ioutil.WriteFile(filepath.Join(tmpDir, "google.com.key"), []byte{}, os.FileMode(0400))
 			},
 			host:    "google.com",
-			site:    dockerproxy.Site{Env: map[string]string{}},
+			site:    dockerproxy.Site{Config: map[string]string{}},
 			wantOut: filepath.Join(tmpDir, "google.com"),
 			wantOk:  true,
 		},
 		{
 			makeFile: func() {},
 			host:     "www.doesnotmatter.com",
-			site:     dockerproxy.Site{Env: map[string]string{}},
+			site:     dockerproxy.Site{Config: map[string]string{}},
 			wantOut:  "",
 			wantOk:   false,
 		},
@@ -118,7 +270,7 @@ func TestSSLInfo(t *testing.T) {
 				ioutil.WriteFile(filepath.Join(tmpDir, "somekey.key"), []byte{}, os.FileMode(0400))
 			},
 			host:    "www.doesnotmatch.com",
-			site:    dockerproxy.Site{Env: map[string]string{"CERT_NAME": "somekey"}},
+			site:    dockerproxy.Site{Config: map[string]string{"CERT_NAME": "somekey"}},
 			wantOut: filepath.Join(tmpDir, "somekey"),
 			wantOk:  true,
 		},
@@ -129,7 +281,7 @@ func TestSSLInfo(t *testing.T) {
 				ioutil.WriteFile(filepath.Join(tmpDir, "almost_matches_both.key"), []byte{}, os.FileMode(0400))
 			},
 			host:    "www.doesnotmatch.com",
-			site:    dockerproxy.Site{Env: map[string]string{"CERT_NAME": "almost_matches_both"}},
+			site:    dockerproxy.Site{Config: map[string]string{"CERT_NAME": "almost_matches_both"}},
 			wantOk:  false,
 			wantOut: "",
 		},
@@ -138,7 +290,7 @@ func TestSSLInfo(t *testing.T) {
 		v.makeFile()
 		got, gotOk := s.sslInfo(v.host, v.site)
 		if gotOk != v.wantOk {
-			t.Fatalf("wanted ok: %q, got: %q", v.wantOk, gotOk)
+			t.Fatalf("wanted ok: %v, got: %v", v.wantOk, gotOk)
 		}
 
 		if got != v.wantOut {
@@ -196,8 +348,8 @@ server {
 	}
 
 	buf := bytes.NewBuffer(nil)
-	site := dockerproxy.Site{Env: map[string]string{}, ID: "my-id-upstream"}
-	err = s.renderHost(buf, "www.google.com", site)
+	site := dockerproxy.Site{Config: map[string]string{}, ID: "my-id-upstream"}
+	_, _, err = s.renderHost(buf, "www.google.com", []dockerproxy.Site{site}, false)
 	got := buf.String()
 	toCompare := strings.Replace(got, tmpDir, "ssldir", -1)
 	if toCompare != wantOut {
@@ -243,7 +395,7 @@ func TestHTTPAuthInfo(t *testing.T) {
 			htpasswd: dir,
 		}
 		site := dockerproxy.Site{
-			Env: v.env,
+			Config: v.env,
 		}
 		fn, err := s.httpAuthInfo(v.site, site)
 
@@ -272,3 +424,267 @@ func TestHTTPAuthInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateStreams(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nginxtest")
+	if err != nil {
+		t.Fatalf("unable to make temp dir: %q", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("unable to remove temp files: %s", err)
+		}
+	}()
+
+	cfg := filepath.Join(tmpDir, "nginx.conf")
+	s, err := New(tmpDir, cfg, "/bin/true", tmpDir)
+	if err != nil {
+		t.Fatalf("unable to create new server: %s", err)
+	}
+	s.StreamConfig = filepath.Join(tmpDir, "stream.conf")
+
+	sites := []dockerproxy.Site{
+		{
+			ID:     "udp_53",
+			Proto:  "udp",
+			Listen: 53,
+			Backends: []dockerproxy.Backend{
+				{ID: "dns", Contact: dockerproxy.Mapping{Address: "10.0.1.3", Port: 53, Network: "overlay-net"}},
+			},
+			Config: map[string]string{"NGINX_PROXY_TIMEOUT": "10s"},
+		},
+		{
+			ID:     "tcp_5432",
+			Proto:  "tcp",
+			Listen: 5432,
+			Backends: []dockerproxy.Backend{
+				{ID: "db", Contact: dockerproxy.Mapping{Address: "10.0.1.2", Port: 5432, Network: "overlay-net"}},
+			},
+			Config: map[string]string{"NGINX_CLIENT_MAX_BODY_SIZE": "5m"},
+		},
+		{
+			ID:   "www.google.com",
+			Host: "www.google.com",
+			Path: "/",
+			Backends: []dockerproxy.Backend{
+				{ID: "web", Contact: dockerproxy.Mapping{Address: "10.0.1.4", Port: 80, Network: "overlay-net"}},
+			},
+			Config: map[string]string{},
+		},
+	}
+	if err := s.Update(sites); err != nil {
+		t.Fatalf("wanted no error updating config, got: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(cfg)
+	if err != nil {
+		t.Fatalf("unable to read written config: %s", err)
+	}
+	if !strings.Contains(string(data), "server_name www.google.com;") {
+		t.Fatalf("wanted config to contain the HTTP site, got: %s", data)
+	}
+	if strings.Contains(string(data), "tcp_5432") || strings.Contains(string(data), "udp_53") {
+		t.Fatalf("wanted config to leave out stream sites, got: %s", data)
+	}
+
+	stream, err := ioutil.ReadFile(s.StreamConfig)
+	if err != nil {
+		t.Fatalf("unable to read written stream config: %s", err)
+	}
+	wantOutput := `stream {
+
+upstream udp_53 {
+	## Container: dns, Network: overlay-net
+	server 10.0.1.3:53;
+}
+
+server {
+	listen 53 udp;
+	proxy_timeout 10s;
+	 
+	proxy_pass udp_53;
+}
+
+upstream tcp_5432 {
+	## Container: db, Network: overlay-net
+	server 10.0.1.2:5432;
+}
+
+server {
+	listen 5432;
+	 
+	proxy_pass tcp_5432;
+}
+}
+`
+	if string(stream) != wantOutput {
+		t.Fatalf("want: %q\n got: %q\n", wantOutput, stream)
+	}
+}
+
+func TestLocationProtocols(t *testing.T) {
+	s, err := New(filepath.Join("testdata", "proto"), os.TempDir(), "/bin/true", "")
+	if err != nil {
+		t.Fatalf("unable to create new server: %s", err)
+	}
+	for _, proto := range []string{"http", "https", "grpc", "grpcs", "uwsgi", "fastcgi"} {
+		site := dockerproxy.Site{
+			ID:        "app.google.com_api",
+			Path:      "/api",
+			StripPath: proto == "http" || proto == "https",
+			Config:    map[string]string{},
+		}
+		if proto != "http" {
+			site.Proto = proto
+		}
+		// gRPC is only served with a certificate, which is in testdata
+		host := "app.google.com"
+		if proto == "grpc" || proto == "grpcs" {
+			host = "grpc.google.com"
+		}
+
+		buf := bytes.NewBuffer(nil)
+		if _, _, err := s.renderHost(buf, host, []dockerproxy.Site{site}, false); err != nil {
+			t.Fatalf("wanted no error rendering %s site, got: %v", proto, err)
+		}
+		want, err := ioutil.ReadFile(filepath.Join("testdata", "proto", proto+".conf"))
+		if err != nil {
+			t.Fatalf("unable to read golden file: %s", err)
+		}
+		if buf.String() != string(want) {
+			t.Fatalf("%s: want: %q\n got: %q\n", proto, want, buf.String())
+		}
+	}
+
+	site := dockerproxy.Site{ID: "app.google.com", Path: "/", Proto: "grpc", Config: map[string]string{}}
+	buf := bytes.NewBuffer(nil)
+	written, _, err := s.renderHost(buf, "app.google.com", []dockerproxy.Site{site}, false)
+	if err != nil {
+		t.Fatalf("wanted no error rendering gRPC site without a certificate, got: %v", err)
+	}
+	if written || buf.Len() != 0 {
+		t.Fatalf("wanted gRPC site without a certificate to be left out, got: %q", buf.String())
+	}
+}
+
+func TestUpdateDefaultHost(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nginxtest")
+	if err != nil {
+		t.Fatalf("unable to make temp dir: %q", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("unable to remove temp files: %s", err)
+		}
+	}()
+
+	site := func(host string, def bool) dockerproxy.Site {
+		return dockerproxy.Site{
+			ID:   host,
+			Host: host,
+			Path: "/",
+			Backends: []dockerproxy.Backend{
+				{ID: "a", Contact: dockerproxy.Mapping{Address: "10.0.1.2", Port: 80, Network: "overlay-net"}},
+			},
+			Config:  map[string]string{},
+			Default: def,
+		}
+	}
+	sites := []dockerproxy.Site{site("api.google.com", false), site("www.google.com", true)}
+	// secure.google.com has a certificate
+	for _, ext := range []string{".crt", ".key"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpDir, "secure.google.com"+ext), nil, 0644); err != nil {
+			t.Fatalf("unable to write certificate: %s", err)
+		}
+	}
+	secure := site("secure.google.com", false)
+	grpc := site("www.google.com", true)
+	grpc.Proto = "grpc"
+
+	tt := []struct {
+		name     string
+		fallback string
+		sites    []dockerproxy.Site
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:  "catch-all",
+			sites: sites[:1],
+			want: []string{
+				"listen 80 default_server;\n\tlocation / {\n\t\treturn 503;",
+				"server_name api.google.com;\n\tlisten 80;",
+			},
+			dontWant: []string{"listen 443"},
+		},
+		{
+			name:  "https catch-all",
+			sites: []dockerproxy.Site{sites[0], secure},
+			want: []string{
+				"listen 80 default_server;\n\tlocation / {\n\t\treturn 503;",
+				"listen 443 ssl default_server;\n\tssl_reject_handshake on;",
+				"server_name secure.google.com;\n\tlisten 443 ssl http2;",
+			},
+		},
+		{
+			name:     "fallback page",
+			fallback: "/srv/www/unknown.html",
+			sites:    sites[:1],
+			want: []string{
+				"root /srv/www/;\n\terror_page 503 /unknown.html;\n\tlocation = /unknown.html {\n\t\tinternal;\n\t}",
+			},
+		},
+		{
+			name:     "default host",
+			fallback: "/srv/www/unknown.html",
+			sites:    sites,
+			want: []string{
+				"server_name api.google.com;\n\tlisten 80;",
+				"server_name www.google.com;\n\tlisten 80 default_server;",
+			},
+			dontWant: []string{"return 503;", "unknown.html", "listen 443"},
+		},
+		{
+			name:     "default host with https",
+			sites:    []dockerproxy.Site{sites[0], site("www.google.com", true), secure},
+			want:     []string{"ssl_reject_handshake on;", "server_name www.google.com;\n\tlisten 80 default_server;"},
+			dontWant: []string{"return 503;"},
+		},
+		{
+			name:     "default host not written",
+			fallback: "/srv/www/unknown.html",
+			sites:    []dockerproxy.Site{sites[0], grpc},
+			want: []string{
+				"listen 80 default_server;\n\troot /srv/www/;",
+				"return 503;",
+			},
+			dontWant: []string{"server_name www.google.com;"},
+		},
+	}
+	for _, v := range tt {
+		cfg := filepath.Join(tmpDir, v.name+".conf")
+		s, err := New(tmpDir, cfg, "/bin/true", tmpDir)
+		if err != nil {
+			t.Fatalf("unable to create new server: %s", err)
+		}
+		s.FallbackPage = v.fallback
+		if err := s.Update(v.sites); err != nil {
+			t.Fatalf("%s: wanted no error updating config, got: %v", v.name, err)
+		}
+
+		data, err := ioutil.ReadFile(cfg)
+		if err != nil {
+			t.Fatalf("unable to read written config: %s", err)
+		}
+		for _, want := range v.want {
+			if !strings.Contains(string(data), want) {
+				t.Fatalf("%s: wanted config to contain %q, got: %s", v.name, want, data)
+			}
+		}
+		for _, dontWant := range v.dontWant {
+			if strings.Contains(string(data), dontWant) {
+				t.Fatalf("%s: wanted config not to contain %q, got: %s", v.name, dontWant, data)
+			}
+		}
+	}
+}
@@ -42,31 +42,54 @@ proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
 proxy_set_header X-Forwarded-Proto $proxy_x_forwarded_proto;
 proxy_set_header X-Forwarded-Ssl $proxy_x_forwarded_ssl;
 
-# Set the default server
+{{- if not .DefaultHTTP }}
+
+# Answer unknown hosts with a 503
 server {
 	server_name _;
-	listen 80;
-	return 503;
+	listen 80 default_server;
+	{{- if .FallbackName }}
+	root {{ .FallbackRoot }};
+	error_page 503 /{{ .FallbackName }};
+	location = /{{ .FallbackName }} {
+		internal;
+	}
+	{{- end }}
+	location / {
+		return 503;
+	}
 }
+{{- end }}
+{{- if and .AnySSL (not .DefaultHTTPS) }}
+
+# Reject HTTPS for unknown hosts, rather than answer with another site's certificate
+server {
+	server_name _;
+	listen 443 ssl default_server;
+	ssl_reject_handshake on;
+}
+{{- end }}
 `
 
 var nginxUpstream = `
 upstream {{ .ID }} {
-	## Network: {{ .Contact.Network }}
-	server {{ .Contact.Address }}:{{ .Contact.Port }};
+{{- range .Backends }}
+	## Container: {{ .ID }}, Network: {{ .Contact.Network }}
+	server {{ .Contact.Address }}:{{ .Contact.Port }}{{ if .Weight }} weight={{ .Weight }}{{ end }}{{ if .Backup }} backup{{ end }}{{ if .Down }} down{{ end }};
+{{- end }}
 }
 `
 
 var nginxWithSSL = `
 server {
 	server_name {{ .Host }};
-	listen 80;
+	listen 80{{ if .Default }} default_server{{ end }};
 	return 301 https://$host$request_uri;
 }
 
 server {
 	server_name {{ .Host }};
-	listen 443 ssl http2;
+	listen 443 ssl http2{{ if .Default }} default_server{{ end }};
 
 	ssl_protocols TLSv1 TLSv1.1 TLSv1.2;
 	ssl_ciphers 'ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES256-SHA384:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES128-SHA:DHE-RSA-AES256-SHA256:DHE-RSA-AES256-SHA:ECDHE-ECDSA-DES-CBC3-SHA:ECDHE-RSA-DES-CBC3-SHA:EDH-RSA-DES-CBC3-SHA:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA:!DSS';
@@ -78,22 +101,60 @@ server {
 	ssl_certificate_key {{ .SSLPrefix }}.key;
 	add_header Strict-Transport-Security "max-age=31536000";
 	{{ template "config" .Config }}
-	location / {
-		proxy_pass http://{{ .ID }};
-	}
+	{{- range .Locations }}
+	{{ template "location" . }}
+	{{- end }}
 }
 `
 
 var nginxNoSSL = `
 server {
 	server_name {{ .Host }};
-	listen 80;
+	listen 80{{ if .Default }} default_server{{ end }};
 	{{ template "config" .Config }}
-	location / {
-		proxy_pass http://{{ .ID }};
-	}
+	{{- range .Locations }}
+	{{ template "location" . }}
+	{{- end }}
 }
 `
 
+var nginxStream = `stream {
+{{- range . }}
+{{ template "nginxUpstream" .Site }}
+server {
+	listen {{ .Listen }}{{ if eq .Proto "udp" }} udp{{ end }};
+	{{ template "config" .Directives }}
+	proxy_pass {{ .ID }};
+}
+{{- end }}
+}
+`
+
+// nginxLocation passes a location to its upstream with the directive for
+// the protocol the backends speak, which is plain HTTP by default
+var nginxLocation = `{{ define "location" }}location {{ .Path }} {
+	{{- if eq .Proto "https" }}
+		proxy_ssl_server_name on;
+		proxy_ssl_name $host;
+		proxy_pass https://{{ .ID }}{{ .URI }};
+	{{- else if or (eq .Proto "grpc") (eq .Proto "grpcs") }}
+		grpc_set_header Host $http_host;
+		grpc_set_header X-Real-IP $remote_addr;
+		grpc_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+		grpc_pass {{ .Proto }}://{{ .ID }};
+	{{- else if eq .Proto "uwsgi" }}
+		include uwsgi_params;
+		uwsgi_param HTTP_X_FORWARDED_PROTO $proxy_x_forwarded_proto;
+		uwsgi_pass {{ .ID }};
+	{{- else if eq .Proto "fastcgi" }}
+		include fastcgi_params;
+		fastcgi_param SCRIPT_FILENAME $document_root$fastcgi_script_name;
+		fastcgi_index index.php;
+		fastcgi_pass {{ .ID }};
+	{{- else }}
+		proxy_pass http://{{ .ID }}{{ .URI }};
+	{{- end }}
+	}{{ end }}`
+
 var nginxOptions = `{{ define "config" }}{{ range $key, $value := . }}{{ $key }} {{ $value }};
 	{{ end }} {{ end }}`
@@ -0,0 +1,243 @@
+package dockerproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrRuleCoversNothing is returned for a host policy rule without any
+	// hosts or ports
+	ErrRuleCoversNothing = errors.New("host policy rule has no hosts or ports")
+	// ErrRuleMatchesNothing is returned for a host policy rule without any
+	// labels, projects or networks
+	ErrRuleMatchesNothing = errors.New("host policy rule has no labels, projects or networks")
+)
+
+// HostPolicy restricts which containers may claim which hosts, so that on
+// an engine shared by several tenants, one can't take over the traffic of
+// another. Each rule covers a set of hosts, and a container may only claim
+// a covered host if it matches the most specific of the rules covering it.
+// A wildcard claim must also match every rule covering a host the wildcard
+// would serve.
+type HostPolicy struct {
+	Rules []HostRule `json:"rules"`
+	// DenyUnlisted stops anyone from claiming hosts that no rule covers
+	DenyUnlisted bool `json:"deny_unlisted"`
+}
+
+// HostRule covers hosts and ports, and a container matches the rule if it
+// matches any of Labels, Projects or Networks. Labels and Projects are set
+// by whoever starts the container, so they only keep apart tenants that
+// can't set their own labels.
+type HostRule struct {
+	// Hosts are either exact hosts or wildcards such as *.example.com,
+	// covering every subdomain
+	Hosts []string `json:"hosts"`
+	// Ports are the ports tcp and udp sites listen on
+	Ports []int64 `json:"ports"`
+	// Labels must all be set on the container
+	Labels map[string]string `json:"labels"`
+	// Projects are compose projects or swarm stacks
+	Projects []string `json:"projects"`
+	Networks []string `json:"networks"`
+}
+
+// LoadHostPolicy reads a host policy from a JSON file
+func LoadHostPolicy(name string) (*HostPolicy, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseHostPolicy(file)
+}
+
+// parseHostPolicy decodes a host policy, normalizing the hosts of every
+// rule the same way the hosts of containers are. As a misspelled key would
+// leave hosts unprotected, unknown keys and rules that can't cover or match
+// anything are errors.
+func parseHostPolicy(r io.Reader) (*HostPolicy, error) {
+	p := &HostPolicy{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	for i, rule := range p.Rules {
+		if len(rule.Hosts) == 0 && len(rule.Ports) == 0 {
+			return nil, ErrRuleCoversNothing
+		}
+		if len(rule.Labels) == 0 && len(rule.Projects) == 0 && len(rule.Networks) == 0 {
+			return nil, ErrRuleMatchesNothing
+		}
+		for j, h := range rule.Hosts {
+			host, err := normalizeHost(h)
+			if err != nil {
+				return nil, err
+			}
+			p.Rules[i].Hosts[j] = host
+		}
+	}
+	return p, nil
+}
+
+// authorized reports whether a backend may claim host. A nil policy
+// authorizes everything.
+func (p *HostPolicy) authorized(host string, b Backend) bool {
+	if p == nil {
+		return true
+	}
+	best, allowed := -1, false
+	for _, rule := range p.Rules {
+		score := rule.covers(host)
+		if score < 0 {
+			if isWildcard(host) && rule.overlaps(host) && !rule.matches(b) {
+				return false
+			}
+			continue
+		}
+		switch {
+		case score > best:
+			best, allowed = score, rule.matches(b)
+		case score == best:
+			allowed = allowed || rule.matches(b)
+		}
+	}
+	if best < 0 {
+		return !p.DenyUnlisted
+	}
+	return allowed
+}
+
+// authorizedPort reports whether a backend may listen on port with a tcp or
+// udp site, in the same way as authorized does for hosts
+func (p *HostPolicy) authorizedPort(port int64, b Backend) bool {
+	if p == nil {
+		return true
+	}
+	covered := false
+	for _, rule := range p.Rules {
+		if !rule.coversPort(port) {
+			continue
+		}
+		if rule.matches(b) {
+			return true
+		}
+		covered = true
+	}
+	return !covered && !p.DenyUnlisted
+}
+
+// authorizedDefault reports whether a backend may be the default host. As
+// the default host is sent requests for every host without a site, which
+// includes the hosts of every rule, it has to match all of them, and can't
+// be set if unlisted hosts are denied.
+func (p *HostPolicy) authorizedDefault(b Backend) bool {
+	if p == nil {
+		return true
+	}
+	if p.DenyUnlisted {
+		return false
+	}
+	for _, rule := range p.Rules {
+		if !rule.matches(b) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r HostRule) coversPort(port int64) bool {
+	for _, v := range r.Ports {
+		if v == port {
+			return true
+		}
+	}
+	return false
+}
+
+// covers returns how specific the most specific of the rule's hosts
+// covering host is, or -1 if none do. An exact host is more specific than
+// any wildcard, and longer wildcards are more specific than shorter ones.
+func (r HostRule) covers(host string) int {
+	best := -1
+	for _, v := range r.Hosts {
+		switch {
+		case v == host:
+			return math.MaxInt32
+		case wildcardCovers(v, host) && len(v) > best:
+			best = len(v)
+		}
+	}
+	return best
+}
+
+// overlaps reports whether a wildcard claim would serve any of the hosts
+// the rule covers, without the rule covering the claim
+func (r HostRule) overlaps(claim string) bool {
+	for _, v := range r.Hosts {
+		if wildcardCovers(claim, v) {
+			return true
+		}
+		// a leading and a trailing wildcard, such as *.example.com and
+		// www.*, both serve www.example.com
+		if isWildcard(v) && strings.HasPrefix(v, "*.") != strings.HasPrefix(claim, "*.") {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardCovers reports whether the wildcard pattern serves every host
+// that host does, which may itself be a wildcard
+func wildcardCovers(pattern, host string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(host, pattern[1:]) && !strings.HasSuffix(host, ".*")
+	case strings.HasSuffix(pattern, ".*"):
+		return strings.HasPrefix(host, pattern[:len(pattern)-1]) && !strings.HasPrefix(host, "*.")
+	}
+	return false
+}
+
+func isWildcard(host string) bool {
+	return strings.HasPrefix(host, "*.") || strings.HasSuffix(host, ".*")
+}
+
+func (r HostRule) matches(b Backend) bool {
+	if len(r.Labels) > 0 && hasLabels(b.Labels, r.Labels) {
+		return true
+	}
+	if b.Project != "" && contains(r.Projects, b.Project) {
+		return true
+	}
+	for _, v := range b.Networks {
+		if contains(r.Networks, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
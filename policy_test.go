@@ -0,0 +1,133 @@
+package dockerproxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadHostPolicy(t *testing.T) {
+	p, err := LoadHostPolicy("testdata/policy/policy.json")
+	if err != nil {
+		t.Fatalf("wanted no error loading policy, got: %s", err)
+	}
+	want := &HostPolicy{
+		DenyUnlisted: true,
+		Rules: []HostRule{
+			{Hosts: []string{"example.com", "*.example.com"}, Projects: []string{"shop"}, Labels: map[string]string{"team": "web"}},
+			{Hosts: []string{"staging.example.com"}, Networks: []string{"staging"}},
+		},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("wanted policy: %#v, got: %#v", want, p)
+	}
+
+	tt := []struct {
+		policy string
+		err    error
+	}{
+		{policy: `{"rules": [{"hosts": ["bad_host.com"], "networks": ["web"]}]}`},
+		{policy: `{"rules": [{"host": ["example.com"], "networks": ["web"]}]}`},
+		{policy: `{"rules": [{"networks": ["web"]}]}`, err: ErrRuleCoversNothing},
+		{policy: `{"rules": [{"hosts": ["example.com"]}]}`, err: ErrRuleMatchesNothing},
+		{policy: `{"rules": [{"ports": [5432], "labels": {}, "projects": []}]}`, err: ErrRuleMatchesNothing},
+	}
+	for _, v := range tt {
+		_, err := parseHostPolicy(strings.NewReader(v.policy))
+		if err == nil || (v.err != nil && err != v.err) {
+			t.Fatalf("wanted error %v for %s, got: %v", v.err, v.policy, err)
+		}
+	}
+}
+
+func TestHostPolicyAuthorizedPort(t *testing.T) {
+	p := &HostPolicy{DenyUnlisted: true, Rules: []HostRule{{Ports: []int64{5432}, Networks: []string{"db"}}}}
+	db := Backend{Networks: []string{"db"}}
+	other := Backend{Networks: []string{"bridge"}}
+	tt := []struct {
+		policy *HostPolicy
+		port   int64
+		b      Backend
+		want   bool
+	}{
+		{policy: nil, port: 5432, b: other, want: true},
+		{policy: p, port: 5432, b: db, want: true},
+		{policy: p, port: 5432, b: other, want: false},
+		{policy: p, port: 6379, b: db, want: false},
+		{policy: &HostPolicy{Rules: p.Rules}, port: 6379, b: other, want: true},
+	}
+	for _, v := range tt {
+		if got := v.policy.authorizedPort(v.port, v.b); got != v.want {
+			t.Fatalf("wanted authorized for %d: %v, got: %v", v.port, v.want, got)
+		}
+	}
+}
+
+func TestHostPolicyAuthorizedDefault(t *testing.T) {
+	rules := []HostRule{
+		{Hosts: []string{"example.com"}, Networks: []string{"shop"}},
+		{Hosts: []string{"staging.example.com"}, Networks: []string{"staging"}},
+	}
+	shop := Backend{Networks: []string{"shop"}}
+	both := Backend{Networks: []string{"shop", "staging"}}
+	tt := []struct {
+		policy *HostPolicy
+		b      Backend
+		want   bool
+	}{
+		{policy: nil, b: shop, want: true},
+		{policy: &HostPolicy{}, b: shop, want: true},
+		{policy: &HostPolicy{Rules: rules}, b: shop, want: false},
+		{policy: &HostPolicy{Rules: rules}, b: both, want: true},
+		{policy: &HostPolicy{Rules: rules, DenyUnlisted: true}, b: both, want: false},
+	}
+	for i, v := range tt {
+		if got := v.policy.authorizedDefault(v.b); got != v.want {
+			t.Fatalf("wanted authorized default for case %d: %v, got: %v", i, v.want, got)
+		}
+	}
+}
+
+func TestHostPolicyAuthorized(t *testing.T) {
+	p, err := LoadHostPolicy("testdata/policy/policy.json")
+	if err != nil {
+		t.Fatalf("wanted no error loading policy, got: %s", err)
+	}
+	shop := Backend{Project: "shop", Labels: map[string]string{composeProjectLabel: "shop"}}
+	stack := Backend{Project: "shop", Labels: map[string]string{stackLabel: "shop"}}
+	web := Backend{Labels: map[string]string{"team": "web", "tier": "frontend"}}
+	staging := Backend{Networks: []string{"bridge", "staging"}}
+	other := Backend{Labels: map[string]string{"team": "ops"}, Networks: []string{"bridge"}}
+	both := Backend{Labels: map[string]string{"team": "web"}, Networks: []string{"staging"}}
+	tt := []struct {
+		policy *HostPolicy
+		host   string
+		b      Backend
+		want   bool
+	}{
+		{policy: nil, host: "example.com", b: other, want: true},
+		{policy: p, host: "example.com", b: shop, want: true},
+		{policy: p, host: "example.com", b: stack, want: true},
+		{policy: p, host: "www.example.com", b: web, want: true},
+		{policy: p, host: "a.b.example.com", b: shop, want: true},
+		{policy: p, host: "www.example.com", b: other, want: false},
+		{policy: p, host: "notexample.com", b: shop, want: false},
+		{policy: p, host: "staging.example.com", b: staging, want: true},
+		{policy: p, host: "staging.example.com", b: shop, want: false},
+		{policy: p, host: "www.example.com", b: staging, want: false},
+		{policy: &HostPolicy{Rules: p.Rules}, host: "www.google.com", b: other, want: true},
+		{policy: p, host: "*.example.com", b: web, want: false},
+		{policy: p, host: "*.example.com", b: both, want: true},
+		{policy: p, host: "*.shop.example.com", b: shop, want: true},
+		{policy: &HostPolicy{Rules: p.Rules}, host: "*.com", b: web, want: false},
+		{policy: &HostPolicy{Rules: p.Rules}, host: "*.com", b: both, want: true},
+		{policy: &HostPolicy{Rules: p.Rules}, host: "www.*", b: other, want: false},
+		{policy: &HostPolicy{Rules: p.Rules}, host: "www.*", b: web, want: true},
+		{policy: &HostPolicy{Rules: p.Rules}, host: "*.google.com", b: other, want: true},
+	}
+	for _, v := range tt {
+		if got := v.policy.authorized(v.host, v.b); got != v.want {
+			t.Fatalf("wanted authorized for %s: %v, got: %v", v.host, v.want, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package dockerproxy
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// Provider discovers sites for the Manager to proxy. Docker is one
+// provider, and the sites of every provider are merged on each update.
+type Provider interface {
+	// Name identifies the provider in logs
+	Name() string
+	// Discover returns the sites the provider currently knows of, along
+	// with anything it couldn't turn into a site
+	Discover() (*Report, error)
+	// Watch starts watching for changes, sending on the returned channel
+	// whenever the sites may have changed, until ctx is done
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// resetter is implemented by providers that cache what they discover, so
+// that the cache can be thrown away when it may be out of date
+type resetter interface {
+	Reset()
+}
+
+// forward requests an update whenever a provider reports a change
+func (m *Manager) forward(ctx context.Context, p Provider) {
+	ll := log.WithField("provider", p.Name())
+	changes, err := p.Watch(ctx)
+	if err != nil {
+		ll.WithError(err).Error("unable to watch for changes, sites will only be updated by polling")
+		return
+	}
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			ll.Info("sending update")
+			// the updater stops with ctx, so don't wait on it forever
+			select {
+			case m.update <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// discover merges the reports of every provider into one. Any provider
+// failing fails the update, rather than dropping all of its sites.
+func (m *Manager) discover() (*Report, error) {
+	report := &Report{}
+	var list []Site
+	for _, p := range m.providers {
+		r, err := p.Discover()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r.Sites...)
+		report.Failures = append(report.Failures, r.Failures...)
+		report.Rejected = append(report.Rejected, r.Rejected...)
+	}
+	report.Sites = groupSites(list)
+	report.resolveConflicts(m.conflicts)
+	report.resolveDefault(m.defaultHost)
+	return report, nil
+}
+
+// reset throws away anything the providers have cached
+func (m *Manager) reset() {
+	for _, p := range m.providers {
+		if r, ok := p.(resetter); ok {
+			r.Reset()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package dockerproxy
+
+import "errors"
+
+var (
+	// ErrNoPort is returned when the port of a container to proxy to can't
+	// be determined, because it exposes no ports or more than one
+	ErrNoPort = errors.New("unable to determine port, set VIRTUAL_PORT or use host:port")
+	// ErrNoRoute is returned when a container shares no network with the
+	// proxy and doesn't publish the port being proxied to
+	ErrNoRoute = errors.New("no shared network or published port")
+)
+
+// Reason is a machine readable explanation of why a container was not proxied
+type Reason string
+
+// Reasons a container can be rejected for
+const (
+	ReasonInvalidEnv         Reason = "invalid_env"
+	ReasonNoHost             Reason = "no_host"
+	ReasonInvalidHost        Reason = "invalid_host"
+	ReasonUnauthorizedHost   Reason = "unauthorized_host"
+	ReasonInvalidPath        Reason = "invalid_path"
+	ReasonInvalidUpstream    Reason = "invalid_upstream"
+	ReasonInvalidProto       Reason = "invalid_proto"
+	ReasonInvalidListen      Reason = "invalid_listen"
+	ReasonUnhealthy          Reason = "unhealthy"
+	ReasonInvalidHealthcheck Reason = "invalid_healthcheck"
+	ReasonNoPort             Reason = "no_port"
+	ReasonNoRoute            Reason = "no_route"
+	ReasonConflict           Reason = "conflict"
+	ReasonNotContainer       Reason = "not_container"
+	ReasonNoTasks            Reason = "no_tasks"
+)
+
+// Report is the result of mapping containers to sites. Containers that
+// could not be inspected are recorded as failures, and containers that
+// were inspected but could not be proxied are recorded as rejections,
+// rather than either failing the whole update. Sites claimed by more than
+// one owner are recorded as conflicts.
+type Report struct {
+	Sites     []Site
+	Failures  []Failure
+	Rejected  []Rejection
+	Conflicts []Conflict
+}
+
+// Failure records a container that could not be inspected, usually
+// because it was removed between being listed and being inspected. When
+// aggregating, Engine is set to the engine the container is on, and a
+// failure without an ID is an engine that couldn't be listed.
+type Failure struct {
+	ID     string
+	Names  []string
+	Engine string `json:",omitempty"`
+	Error  string
+}
+
+// Rejection records a container that was not proxied. If only one of the
+// container's hosts was rejected, Host is set to it.
+type Rejection struct {
+	ID     string
+	Names  []string
+	Host   string `json:",omitempty"`
+	Reason Reason
+	Detail string
+}
+
+func (r *Report) reject(id string, names []string, host string, reason Reason, detail string) {
+	r.Rejected = append(r.Rejected, Rejection{
+		ID:     id,
+		Names:  names,
+		Host:   host,
+		Reason: reason,
+		Detail: detail,
+	})
+}
+
+// mappingReason returns the reason for an error from finding a Mapping
+func mappingReason(err error) Reason {
+	if err == ErrNoPort {
+		return ReasonNoPort
+	}
+	return ReasonNoRoute
+}
@@ -0,0 +1,48 @@
+package dockerproxy
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// containerListOptions lists the running containers the proxy may be
+// responsible for. The selector is applied by Docker, so that containers
+// meant for other instances of the proxy are never inspected.
+func containerListOptions(cfg DockerConfig) docker.ListContainersOptions {
+	filters := map[string][]string{"status": {"running"}}
+	if len(cfg.Labels) > 0 {
+		filters["label"] = cfg.Labels
+	}
+	if cfg.Network != "" {
+		filters["network"] = []string{cfg.Network}
+	}
+	return docker.ListContainersOptions{Size: false, Filters: filters}
+}
+
+// serviceListOptions is containerListOptions for swarm services, which
+// can only be filtered by label
+func serviceListOptions(cfg DockerConfig) docker.ListServicesOptions {
+	opts := docker.ListServicesOptions{}
+	if len(cfg.Labels) > 0 {
+		opts.Filters = map[string][]string{"label": cfg.Labels}
+	}
+	return opts
+}
+
+// selected reports whether a container with labels, attached to networks,
+// matches the selector. Every label must match, either by name alone or
+// as name=value, and the container must be attached to the network if one
+// is set. This repeats the filtering done by Docker, since its network
+// filter also matches network IDs, and not every API version filters
+// services by label.
+func selected(cfg DockerConfig, labels map[string]string, networks []string) bool {
+	for _, v := range cfg.Labels {
+		data := strings.SplitN(v, sep, 2)
+		got, ok := labels[data[0]]
+		if !ok || (len(data) == 2 && got != data[1]) {
+			return false
+		}
+	}
+	return cfg.Network == "" || contains(networks, cfg.Network)
+}
@@ -0,0 +1,50 @@
+package dockerproxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerListOptions(t *testing.T) {
+	tt := []struct {
+		cfg  DockerConfig
+		want map[string][]string
+	}{
+		{
+			cfg:  DockerConfig{},
+			want: map[string][]string{"status": {"running"}},
+		},
+		{
+			cfg:  DockerConfig{Labels: []string{"proxy=public", "team"}, Network: "public-net"},
+			want: map[string][]string{"status": {"running"}, "label": {"proxy=public", "team"}, "network": {"public-net"}},
+		},
+	}
+	for _, v := range tt {
+		if got := containerListOptions(v.cfg).Filters; !reflect.DeepEqual(got, v.want) {
+			t.Fatalf("wanted filters: %#v, got: %#v", v.want, got)
+		}
+	}
+}
+
+func TestSelected(t *testing.T) {
+	labels := map[string]string{"proxy": "public", "team": "web"}
+	networks := []string{"bridge", "public-net"}
+	tt := []struct {
+		cfg  DockerConfig
+		want bool
+	}{
+		{cfg: DockerConfig{}, want: true},
+		{cfg: DockerConfig{Labels: []string{"proxy=public"}}, want: true},
+		{cfg: DockerConfig{Labels: []string{"proxy=public", "team"}}, want: true},
+		{cfg: DockerConfig{Labels: []string{"proxy=internal"}}, want: false},
+		{cfg: DockerConfig{Labels: []string{"proxy", "owner"}}, want: false},
+		{cfg: DockerConfig{Network: "public-net"}, want: true},
+		{cfg: DockerConfig{Network: "internal-net"}, want: false},
+		{cfg: DockerConfig{Labels: []string{"proxy=public"}, Network: "internal-net"}, want: false},
+	}
+	for _, v := range tt {
+		if got := selected(v.cfg, labels, networks); got != v.want {
+			t.Fatalf("wanted selected with %#v: %v, got: %v", v.cfg, v.want, got)
+		}
+	}
+}
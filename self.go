@@ -0,0 +1,119 @@
+package dockerproxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+)
+
+// defaultHostNetworks are the networks the proxy can reach when running on
+// the host, if it wasn't told otherwise
+var defaultHostNetworks = []string{"bridge"}
+
+// self describes where the proxy is running: the ID of its container and
+// the networks it shares with other containers. Address is where ports
+// that Docker publishes on every interface are reached, if they can be.
+// When running on the host, there is no container, and published ports
+// are reached over localhost. Remote is set for the followers of an
+// aggregating proxy, whose loopback addresses the proxy can't reach.
+type self struct {
+	ID       string
+	Networks []string
+	Address  string
+	Remote   bool
+}
+
+var (
+	// ErrUnknownContainer is returned when the ID of the container the proxy
+	// is running in can't be detected
+	ErrUnknownContainer = errors.New("unable to fetch container ID, set CONTAINER_ID")
+
+	// cgroupRegex matches the container ID at the end of a cgroup path, which
+	// covers /docker/<id> under cgroupfs, docker-<id>.scope under systemd, as
+	// well as the libpod-, crio- and cri-containerd- scopes of other runtimes
+	cgroupRegex = regexp.MustCompile(`[/-]([[:xdigit:]]{64})(\.scope)?(/container)?$`)
+
+	// mountinfoRegex matches the per-container files that Docker and Podman
+	// bind mount into the container, for when the cgroup path is hidden by a
+	// cgroup namespace, such as under cgroup v2
+	mountinfoRegex = regexp.MustCompile(`containers/([[:xdigit:]]{64})/(userdata/)?(hostname|hosts|resolv\.conf) `)
+
+	// hostnameRegex matches the short container ID Docker and Podman use as
+	// the default hostname
+	hostnameRegex = regexp.MustCompile(`^[[:xdigit:]]{12}$`)
+)
+
+// findSelf returns where the proxy is running. On the host, the networks
+// are the ones configured, otherwise they are the networks attached to
+// the proxy's own container.
+func findSelf(client inspector, cfg DockerConfig) (self, error) {
+	if cfg.HostMode {
+		nets := cfg.HostNetworks
+		if len(nets) == 0 {
+			nets = defaultHostNetworks
+		}
+		return self{Networks: nets, Address: "127.0.0.1"}, nil
+	}
+
+	me, err := currentContainerID()
+	if err != nil {
+		return self{}, err
+	}
+
+	c, err := client.InspectContainer(me)
+	if err != nil {
+		return self{}, err
+	}
+	out := make([]string, 0, len(c.NetworkSettings.Networks))
+	for k := range c.NetworkSettings.Networks {
+		out = append(out, k)
+	}
+	// the detected ID may only be a prefix, such as the hostname
+	return self{ID: c.ID, Networks: out}, nil
+}
+
+// currentContainerID returns the ID of the container the proxy is running in.
+// CONTAINER_ID takes precedence, otherwise the ID is detected from the cgroup
+// and mount tables, falling back to the hostname. The hostname is only a short
+// ID, which Docker will still accept when inspecting the container.
+func currentContainerID() (string, error) {
+	if id, ok := os.LookupEnv("CONTAINER_ID"); ok {
+		return id, nil
+	}
+
+	if id, ok := idFromFile("/proc/self/cgroup", cgroupRegex); ok {
+		return id, nil
+	}
+
+	if id, ok := idFromFile("/proc/self/mountinfo", mountinfoRegex); ok {
+		return id, nil
+	}
+
+	if host, err := os.Hostname(); err == nil && hostnameRegex.MatchString(host) {
+		return host, nil
+	}
+
+	return "", ErrUnknownContainer
+}
+
+func idFromFile(name string, re *regexp.Regexp) (string, bool) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	return findID(file, re)
+}
+
+// findID returns the first container ID matched by re on any line of r
+func findID(r io.Reader, re *regexp.Regexp) (string, bool) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if submatches := re.FindStringSubmatch(scanner.Text()); submatches != nil {
+			return submatches[1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,62 @@
+package dockerproxy
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestFindID(t *testing.T) {
+	const (
+		dockerID = "3c5e5a4c2dc76f7b5d3b57b0d5df1e0c0c8a3a6b1ad21e87b7a1f5e2b2b1e7c4"
+		podmanID = "a1b2c3d4e5f60718293a4b5c6d7e8f90112233445566778899aabbccddeeff00"
+	)
+	tt := []struct {
+		file string
+		re   *regexp.Regexp
+		want string
+		ok   bool
+	}{
+		{file: "cgroup/docker-v1", re: cgroupRegex, want: dockerID, ok: true},
+		{file: "cgroup/docker-v1-systemd", re: cgroupRegex, want: dockerID, ok: true},
+		{file: "cgroup/docker-v2-systemd", re: cgroupRegex, want: dockerID, ok: true},
+		{file: "cgroup/docker-v2-namespaced", re: cgroupRegex, want: "", ok: false},
+		{file: "cgroup/podman-v2", re: cgroupRegex, want: podmanID, ok: true},
+		{file: "cgroup/kubepods-containerd", re: cgroupRegex, want: dockerID, ok: true},
+		{file: "cgroup/host", re: cgroupRegex, want: "", ok: false},
+		{file: "mountinfo/docker", re: mountinfoRegex, want: dockerID, ok: true},
+		{file: "mountinfo/podman", re: mountinfoRegex, want: podmanID, ok: true},
+		{file: "mountinfo/host", re: mountinfoRegex, want: "", ok: false},
+	}
+	for _, v := range tt {
+		fi, err := os.Open(filepath.Join("testdata", v.file))
+		if err != nil {
+			t.Fatalf("unable to open fixture: %s", err)
+		}
+		id, ok := findID(fi, v.re)
+		fi.Close()
+		if ok != v.ok {
+			t.Errorf("%s: wanted ok: %v, got: %v", v.file, v.ok, ok)
+		}
+		if id != v.want {
+			t.Fatalf("%s: wanted id: %q, got: %q", v.file, v.want, id)
+		}
+	}
+}
+
+func TestHostnameID(t *testing.T) {
+	tt := []struct {
+		host string
+		want bool
+	}{
+		{host: "3c5e5a4c2dc7", want: true},
+		{host: "nginxproxy", want: false},
+		{host: "3c5e5a4c2dc76f7b", want: false},
+	}
+	for _, v := range tt {
+		if got := hostnameRegex.MatchString(v.host); got != v.want {
+			t.Fatalf("wanted %q to match: %v, got: %v", v.host, v.want, got)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package dockerproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/context"
+)
+
+// staticOwner owns every static backend, so that a container claiming the
+// host of a static site is treated as a conflict
+const staticOwner = "static"
+
+var (
+	// ErrNoBackends is returned when a static site has no backends
+	ErrNoBackends = errors.New("static site has no backends")
+
+	// ErrInvalidBackend is returned when a static backend is missing its
+	// address or port
+	ErrInvalidBackend = errors.New("static backends need an address and port")
+)
+
+// StaticSite is a site that isn't discovered from Docker, such as a VM or
+// an external service, which is proxied alongside the discovered sites.
+// Config takes the same options as the environment of a container.
+type StaticSite struct {
+	Host     string
+	Path     string
+	Config   map[string]string
+	Backends []Mapping
+}
+
+// staticSites is a provider of the sites last read from the static sites file
+type staticSites struct {
+	name  string
+	sites []Site
+	sync.Mutex
+}
+
+func (s *staticSites) Name() string {
+	return "static"
+}
+
+func (s *staticSites) Discover() (*Report, error) {
+	return &Report{Sites: s.get()}, nil
+}
+
+func (s *staticSites) get() []Site {
+	s.Lock()
+	defer s.Unlock()
+	return s.sites
+}
+
+// load reads the static sites file. If it can't be read, the sites from
+// the last time it could be are kept.
+func (s *staticSites) load() error {
+	file, err := os.Open(s.name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sites, err := parseStaticSites(file)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.sites = sites
+	s.Unlock()
+	return nil
+}
+
+// Watch reloads the static sites file whenever it changes, sending on the
+// returned channel after every successful reload. The directory is watched
+// rather than the file, since editors and config management usually
+// replace the file instead of writing to it.
+func (s *staticSites) Watch(ctx context.Context) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(filepath.Dir(s.name)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	changes := make(chan struct{})
+	go func() {
+		defer w.Close()
+		ll := log.WithField("file", s.name)
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(s.name) || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if err := s.load(); err != nil {
+					ll.WithError(err).Error("unable to reload static sites, keeping the previous ones")
+					continue
+				}
+				ll.Info("reloaded static sites")
+				select {
+				case changes <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				ll.WithError(err).Error("error watching static sites")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// parseStaticSites decodes a list of static sites, and converts them into
+// sites the same way containers are. Every site must be valid, so that a
+// mistake in the file doesn't silently drop a site.
+func parseStaticSites(r io.Reader) ([]Site, error) {
+	var file struct {
+		Sites []StaticSite
+	}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	list := make([]Site, 0, len(file.Sites))
+	for _, v := range file.Sites {
+		site, err := staticSite(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, site)
+	}
+	return groupSites(list), nil
+}
+
+func staticSite(v StaticSite) (Site, error) {
+	host, err := normalizeHost(v.Host)
+	if err != nil {
+		return Site{}, err
+	}
+	config := map[string]string{}
+	for k, val := range v.Config {
+		config[k] = val
+	}
+	if v.Path != "" {
+		config[pathKey] = v.Path
+	}
+	path, ok := findPath(config)
+	if !ok {
+		return Site{}, errors.New("invalid path " + strconv.Quote(v.Path) + " for " + host)
+	}
+	proto, ok := findProto(config)
+	if !ok || isStream(proto) {
+		return Site{}, errors.New("invalid " + protoKey + " " + strconv.Quote(config[protoKey]) + " for " + host)
+	}
+	if findPathStrip(config) && !stripsPath(proto) {
+		return Site{}, errors.New(pathStripKey + " can't be used with " + proto + " for " + host)
+	}
+	if len(v.Backends) == 0 {
+		return Site{}, ErrNoBackends
+	}
+
+	site := Site{Host: host, Path: path}
+	for _, m := range v.Backends {
+		if m.Address == "" || m.Port <= 0 {
+			return Site{}, ErrInvalidBackend
+		}
+		m.Network = staticOwner
+		id := staticOwner + "-" + siteID(host, path) + "-" + m.Address + "-" + strconv.FormatInt(m.Port, 10)
+		b := Backend{
+			ID:      id,
+			Names:   []string{staticOwner},
+			Owner:   staticOwner,
+			Proto:   proto,
+			Contact: m,
+			Config:  config,
+		}
+		if err := upstreamOptions(&b); err != nil {
+			return Site{}, errors.New(err.Error() + " for " + host)
+		}
+		site.Backends = append(site.Backends, b)
+	}
+	return site, nil
+}
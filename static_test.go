@@ -0,0 +1,107 @@
+package dockerproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStaticSites(t *testing.T) {
+	s := &staticSites{name: "testdata/static/sites.json"}
+	if err := s.load(); err != nil {
+		t.Fatalf("wanted no error loading static sites, got: %s", err)
+	}
+	sites := s.get()
+	if len(sites) != 2 {
+		t.Fatalf("wanted 2 sites, got: %#v", sites)
+	}
+
+	legacy := sites[0]
+	if legacy.ID != "legacy.example.com" || legacy.Path != "/" || legacy.Config["NGINX_CLIENT_MAX_BODY_SIZE"] != "10m" {
+		t.Fatalf("wanted legacy.example.com with its config, got: %#v", legacy)
+	}
+	want := []Mapping{
+		{Address: "10.1.2.3", Port: 8080, Network: "static"},
+		{Address: "10.1.2.4", Port: 8080, Network: "static"},
+	}
+	var got []Mapping
+	for _, v := range legacy.Backends {
+		if v.Owner != staticOwner {
+			t.Fatalf("wanted static backends to be owned by %q, got: %q", staticOwner, v.Owner)
+		}
+		if v.Weight != 2 {
+			t.Fatalf("wanted static backends to have a weight of 2, got: %d", v.Weight)
+		}
+		got = append(got, v.Contact)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted backends: %#v, got: %#v", want, got)
+	}
+
+	if billing := sites[1]; billing.ID != "www.example.com_billing-ee8d1d1bd4f13b1a" || billing.Path != "/billing" || billing.Proto != "https" {
+		t.Fatalf("wanted www.example.com/billing, got: %#v", billing)
+	}
+}
+
+func TestStaticSitesInvalid(t *testing.T) {
+	tt := []string{
+		`{"sites": [{"host": "bad_host", "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [{"host": "www.example.com", "path": "/a b", "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [{"host": "www.example.com"}]}`,
+		`{"sites": [{"host": "www.example.com", "backends": [{"address": "10.0.0.1"}]}]}`,
+		`{"sites": [{"host": "www.example.com", "config": {"VIRTUAL_PROTO": "tcp"}, "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [{"host": "www.example.com", "config": {"VIRTUAL_PROTO": "smtp"}, "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [{"host": "www.example.com", "path": "/api", "config": {"VIRTUAL_PROTO": "grpc", "VIRTUAL_PATH_STRIP": "true"}, "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [{"host": "www.example.com", "config": {"VIRTUAL_WEIGHT": "banana"}, "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [{"host": "www.example.com", "config": {"VIRTUAL_BACKUP": "maybe"}, "backends": [{"address": "10.0.0.1", "port": 80}]}]}`,
+		`{"sites": [`,
+	}
+	for _, v := range tt {
+		if _, err := parseStaticSites(strings.NewReader(v)); err == nil {
+			t.Fatalf("wanted an error parsing %s", v)
+		}
+	}
+}
+
+func TestStaticSitesWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "sites.json")
+	site := `{"sites": [{"host": "%s", "backends": [{"address": "10.0.0.1", "port": 80}]}]}`
+	if err := ioutil.WriteFile(name, []byte(strings.Replace(site, "%s", "a.example.com", 1)), 0644); err != nil {
+		t.Fatalf("unable to write static sites: %s", err)
+	}
+	s := &staticSites{name: name}
+	if err := s.load(); err != nil {
+		t.Fatalf("wanted no error loading static sites, got: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatalf("wanted no error watching static sites, got: %s", err)
+	}
+
+	if err := ioutil.WriteFile(name, []byte(strings.Replace(site, "%s", "b.example.com", 1)), 0644); err != nil {
+		t.Fatalf("unable to write static sites: %s", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("wanted static sites to be reloaded")
+	}
+	if sites := s.get(); len(sites) != 1 || sites[0].Host != "b.example.com" {
+		t.Fatalf("wanted the reloaded site, got: %#v", sites)
+	}
+}
@@ -0,0 +1,209 @@
+package dockerproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/fsouza/go-dockerclient"
+)
+
+// mapServices is the swarm mode equivalent of mapContainers. Every service
+// is read from the swarm, with its routing configuration taken from the
+// service labels, container labels and container environment. Services
+// using a virtual IP are proxied to that IP, while services using DNS round
+// robin are proxied to each of their running tasks.
+func mapServices(client *docker.Client, me self, cfg DockerConfig) (*Report, error) {
+	services, err := client.ListServices(serviceListOptions(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Site, 0, len(services))
+	report := &Report{}
+	for _, svc := range services {
+		spec := svc.Spec.TaskTemplate.ContainerSpec
+		if spec == nil {
+			report.reject(svc.ID, []string{svc.Spec.Name}, "", ReasonNotContainer, "service doesn't run containers")
+			continue
+		}
+
+		env, warnings, err := parseEnv(spec.Env, cfg.LenientEnv)
+		if err != nil {
+			report.reject(svc.ID, []string{svc.Spec.Name}, "", ReasonInvalidEnv, err.Error())
+			continue
+		}
+
+		tasks, err := client.ListTasks(docker.ListTasksOptions{
+			Filters: map[string][]string{
+				"service":       {svc.ID},
+				"desired-state": {"running"},
+			},
+		})
+		if err != nil {
+			report.Failures = append(report.Failures, Failure{ID: svc.ID, Names: []string{svc.Spec.Name}, Error: err.Error()})
+			continue
+		}
+		tasks = runningTasks(tasks)
+		if len(tasks) == 0 {
+			report.reject(svc.ID, []string{svc.Spec.Name}, "", ReasonNoTasks, "service has no running tasks")
+			continue
+		}
+
+		names := networkNames(tasks)
+		networks := make([]string, 0, len(names))
+		for _, v := range names {
+			networks = append(networks, v)
+		}
+		sort.Strings(networks)
+		if !selected(cfg, svc.Spec.Labels, networks) {
+			continue
+		}
+
+		labels := serviceLabels(svc)
+		b := Backend{
+			ID:       svc.ID,
+			Names:    []string{svc.Spec.Name},
+			Project:  svc.Spec.Labels[stackLabel],
+			Service:  stackService(svc),
+			Owner:    "service:" + svc.Spec.Name,
+			Created:  svc.CreatedAt,
+			Env:      env,
+			Labels:   labels,
+			Config:   mergeKV(env, labels),
+			Warnings: warnings,
+		}
+
+		if svc.Endpoint.Spec.Mode != swarm.ResolutionModeDNSRR && len(svc.Endpoint.VirtualIPs) > 0 {
+			b.Networks = networks
+			list = append(list, report.backendSites(b, cfg, func(port int64) (*Mapping, error) {
+				return findVIPMapping(me.Networks, names, svc.Endpoint.VirtualIPs, servicePort(svc, port))
+			})...)
+			continue
+		}
+
+		for _, t := range tasks {
+			tb := b
+			tb.ID = t.ID
+			tb.Names = []string{fmt.Sprintf("%s.%d", svc.Spec.Name, t.Slot)}
+			attachments := t.NetworksAttachments
+			tb.Networks = nil
+			for _, v := range attachments {
+				tb.Networks = append(tb.Networks, v.Network.Spec.Name)
+			}
+			list = append(list, report.backendSites(tb, cfg, func(port int64) (*Mapping, error) {
+				return findTaskMapping(me.Networks, attachments, servicePort(svc, port))
+			})...)
+		}
+	}
+	report.Sites = groupSites(list)
+	return report, nil
+}
+
+// serviceLabels merges the labels of a service with the labels of its
+// containers, with the service labels taking precedence
+func serviceLabels(svc swarm.Service) map[string]string {
+	out := map[string]string{}
+	if spec := svc.Spec.TaskTemplate.ContainerSpec; spec != nil {
+		for k, v := range spec.Labels {
+			out[k] = v
+		}
+	}
+	for k, v := range svc.Spec.Labels {
+		out[k] = v
+	}
+	return out
+}
+
+// stackService returns the name of a service within its stack, which is
+// the name it was given in the compose file
+func stackService(svc swarm.Service) string {
+	stack, ok := svc.Spec.Labels[stackLabel]
+	if !ok {
+		return ""
+	}
+	return strings.TrimPrefix(svc.Spec.Name, stack+"_")
+}
+
+func runningTasks(tasks []swarm.Task) []swarm.Task {
+	out := make([]swarm.Task, 0, len(tasks))
+	for _, v := range tasks {
+		if v.Status.State == swarm.TaskStateRunning {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// servicePort returns the port to proxy a service to. Services don't know
+// which ports their image exposes, so if the port wasn't configured the
+// service must have exactly one port in its endpoint.
+func servicePort(svc swarm.Service, port int64) int64 {
+	if port != 0 {
+		return port
+	}
+	if len(svc.Endpoint.Ports) == 1 {
+		return int64(svc.Endpoint.Ports[0].TargetPort)
+	}
+	return 0
+}
+
+// networkNames maps the IDs of the networks that tasks are attached to to
+// their names, since virtual IPs only carry the network ID
+func networkNames(tasks []swarm.Task) map[string]string {
+	out := map[string]string{}
+	for _, t := range tasks {
+		for _, v := range t.NetworksAttachments {
+			out[v.Network.ID] = v.Network.Spec.Name
+		}
+	}
+	return out
+}
+
+func findVIPMapping(my []string, names map[string]string, vips []swarm.EndpointVirtualIP, port int64) (*Mapping, error) {
+	if port == 0 {
+		return nil, ErrNoPort
+	}
+	for _, v := range my {
+		for _, vip := range vips {
+			if names[vip.NetworkID] != v || vip.Addr == "" {
+				continue
+			}
+			return &Mapping{
+				Address: stripCIDR(vip.Addr),
+				Network: v,
+				Port:    port,
+			}, nil
+		}
+	}
+	return nil, ErrNoRoute
+}
+
+func findTaskMapping(my []string, attachments []swarm.NetworkAttachment, port int64) (*Mapping, error) {
+	if port == 0 {
+		return nil, ErrNoPort
+	}
+	for _, v := range my {
+		for _, att := range attachments {
+			if att.Network.Spec.Name != v || len(att.Addresses) == 0 {
+				continue
+			}
+			return &Mapping{
+				Address: stripCIDR(att.Addresses[0]),
+				Network: v,
+				Port:    port,
+			}, nil
+		}
+	}
+	return nil, ErrNoRoute
+}
+
+// stripCIDR removes the prefix length from an address, since swarm reports
+// addresses as 10.0.0.2/24
+func stripCIDR(addr string) string {
+	if index := strings.Index(addr, "/"); index != -1 {
+		return addr[:index]
+	}
+	return addr
+}
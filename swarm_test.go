@@ -0,0 +1,132 @@
+package dockerproxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestVIPMapping(t *testing.T) {
+	names := map[string]string{"abc": "overlay-net", "def": "ingress"}
+	tt := []struct {
+		my   []string
+		vips []swarm.EndpointVirtualIP
+		port int64
+		want *Mapping
+		err  error
+	}{
+		{
+			my:   []string{"overlay-net"},
+			vips: []swarm.EndpointVirtualIP{{NetworkID: "def", Addr: "10.255.0.4/16"}, {NetworkID: "abc", Addr: "10.0.1.2/24"}},
+			port: 80,
+			want: &Mapping{Address: "10.0.1.2", Port: 80, Network: "overlay-net"},
+			err:  nil,
+		},
+		{
+			my:   []string{"other-net"},
+			vips: []swarm.EndpointVirtualIP{{NetworkID: "abc", Addr: "10.0.1.2/24"}},
+			port: 80,
+			want: nil,
+			err:  ErrNoRoute,
+		},
+		{
+			my:   []string{"overlay-net"},
+			vips: []swarm.EndpointVirtualIP{{NetworkID: "abc", Addr: "10.0.1.2/24"}},
+			port: 0,
+			want: nil,
+			err:  ErrNoPort,
+		},
+	}
+	for _, v := range tt {
+		mapping, err := findVIPMapping(v.my, names, v.vips, v.port)
+		if err != v.err {
+			t.Errorf("wanted err: %v, got: %v", v.err, err)
+		}
+		if !reflect.DeepEqual(mapping, v.want) {
+			t.Fatalf("wanted mapping: %#v, got: %#v", v.want, mapping)
+		}
+	}
+}
+
+func TestTaskMapping(t *testing.T) {
+	attachment := func(name, addr string) swarm.NetworkAttachment {
+		a := swarm.NetworkAttachment{Addresses: []string{addr}}
+		a.Network.Spec.Name = name
+		return a
+	}
+	tt := []struct {
+		my          []string
+		attachments []swarm.NetworkAttachment
+		port        int64
+		want        *Mapping
+		err         error
+	}{
+		{
+			my:          []string{"overlay-net"},
+			attachments: []swarm.NetworkAttachment{attachment("ingress", "10.255.0.5/16"), attachment("overlay-net", "10.0.1.5/24")},
+			port:        8080,
+			want:        &Mapping{Address: "10.0.1.5", Port: 8080, Network: "overlay-net"},
+			err:         nil,
+		},
+		{
+			my:          []string{"overlay-net"},
+			attachments: []swarm.NetworkAttachment{attachment("ingress", "10.255.0.5/16")},
+			port:        8080,
+			want:        nil,
+			err:         ErrNoRoute,
+		},
+	}
+	for _, v := range tt {
+		mapping, err := findTaskMapping(v.my, v.attachments, v.port)
+		if err != v.err {
+			t.Errorf("wanted err: %v, got: %v", v.err, err)
+		}
+		if !reflect.DeepEqual(mapping, v.want) {
+			t.Fatalf("wanted mapping: %#v, got: %#v", v.want, mapping)
+		}
+	}
+}
+
+func TestStackService(t *testing.T) {
+	svc := func(name string, labels map[string]string) swarm.Service {
+		s := swarm.Service{}
+		s.Spec.Name = name
+		s.Spec.Labels = labels
+		return s
+	}
+	tt := []struct {
+		svc  swarm.Service
+		want string
+	}{
+		{svc: svc("shop_web", map[string]string{stackLabel: "shop"}), want: "web"},
+		{svc: svc("web", map[string]string{stackLabel: "shop"}), want: "web"},
+		{svc: svc("shop_web", nil), want: ""},
+	}
+	for _, v := range tt {
+		if got := stackService(v.svc); got != v.want {
+			t.Fatalf("wanted service: %q, got: %q", v.want, got)
+		}
+	}
+}
+
+func TestServicePort(t *testing.T) {
+	one := swarm.Service{Endpoint: swarm.Endpoint{Ports: []swarm.PortConfig{{TargetPort: 8080, PublishedPort: 80}}}}
+	two := swarm.Service{Endpoint: swarm.Endpoint{Ports: []swarm.PortConfig{{TargetPort: 8080}, {TargetPort: 9090}}}}
+	tt := []struct {
+		svc  swarm.Service
+		port int64
+		want int64
+	}{
+		{svc: swarm.Service{}, port: 9001, want: 9001},
+		{svc: swarm.Service{}, port: 0, want: 0},
+		{svc: one, port: 0, want: 8080},
+		{svc: two, port: 0, want: 0},
+		{svc: two, port: 9090, want: 9090},
+	}
+	for _, v := range tt {
+		if got := servicePort(v.svc, v.port); got != v.want {
+			t.Fatalf("wanted port: %d, got: %d", v.want, got)
+		}
+	}
+}
@@ -4,15 +4,9 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/fsouza/go-dockerclient"
 	"golang.org/x/net/context"
 )
 
-var containerListOptions = docker.ListContainersOptions{
-	Size:    false,
-	Filters: map[string][]string{"status": []string{"running"}},
-}
-
 func (m *Manager) updater(ctx context.Context) {
 	listener := debounceChannel(3*time.Second, m.update)
 	for {
@@ -34,24 +28,67 @@ func (m *Manager) updater(ctx context.Context) {
 }
 
 func (m *Manager) updateContainers() error {
-	client, err := m.newClient(m.d.Leader, 0)
+	report, err := m.discover()
 	if err != nil {
 		return err
 	}
 
-	containers, err := client.ListContainers(containerListOptions)
-	if err != nil {
-		return err
+	for _, v := range report.Failures {
+		if v.ID == "" {
+			log.WithFields(log.Fields{
+				"engine": v.Engine,
+				"error":  v.Error,
+			}).Warn("unable to list engine, skipping")
+			continue
+		}
+		log.WithFields(log.Fields{
+			"id":     v.ID,
+			"names":  v.Names,
+			"engine": v.Engine,
+			"error":  v.Error,
+		}).Warn("unable to inspect container, skipping")
 	}
 
-	mapping, err := mapContainers(client, containers)
-	if err != nil {
-		return err
+	for _, v := range report.Rejected {
+		log.WithFields(log.Fields{
+			"id":     v.ID,
+			"names":  v.Names,
+			"host":   v.Host,
+			"reason": v.Reason,
+			"detail": v.Detail,
+		}).Debug("container not proxied")
+	}
+
+	for _, v := range report.Conflicts {
+		log.WithFields(log.Fields{
+			"host":   v.Host,
+			"path":   v.Path,
+			"proto":  v.Proto,
+			"listen": v.Listen,
+			"owners": v.Owners,
+			"policy": v.Policy,
+			"winner": v.Winner,
+		}).Warn("site claimed by more than one owner")
+	}
+
+	for _, v := range report.Sites {
+		for _, w := range v.Warnings {
+			log.WithFields(log.Fields{
+				"site":    v.ID,
+				"warning": w,
+			}).Warn("site has warnings")
+		}
 	}
 
 	for _, v := range m.notify {
-		if err := v.Update(mapping); err != nil {
-			log.WithError(err).WithField("notifier", v.Name()).Error("unable to update mapping")
+		ll := log.WithField("notifier", v.Name())
+		if err := v.Update(report.Sites); err != nil {
+			ll.WithError(err).Error("unable to update mapping")
+		}
+		if r, ok := v.(reporter); ok {
+			if err := r.Report(*report); err != nil {
+				ll.WithError(err).Error("unable to update report")
+			}
 		}
 	}
 
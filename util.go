@@ -2,17 +2,25 @@ package dockerproxy
 
 import (
 	"math"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
 )
 
-var trackedEvents = []string{"create", "destroy", "start", "stop", "die", "restart", "connect", "disconnect"}
+var trackedEvents = []string{"create", "destroy", "start", "stop", "die", "restart", "connect", "disconnect", "update", "remove", "health_status"}
 
+// isTracked reports whether an event should trigger an update. Some actions
+// carry a suffix, such as "health_status: healthy", so only the part before
+// the colon is compared.
 func isTracked(ev *docker.APIEvents) bool {
+	action := ev.Action
+	if index := strings.Index(action, ":"); index != -1 {
+		action = action[:index]
+	}
 	for _, v := range trackedEvents {
-		if ev.Action == v {
+		if action == v {
 			return true
 		}
 	}
@@ -0,0 +1,25 @@
+package dockerproxy
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestIsTracked(t *testing.T) {
+	tt := []struct {
+		action string
+		want   bool
+	}{
+		{action: "start", want: true},
+		{action: "health_status: healthy", want: true},
+		{action: "health_status: unhealthy", want: true},
+		{action: "exec_start: /bin/sh", want: false},
+		{action: "attach", want: false},
+	}
+	for _, v := range tt {
+		if got := isTracked(&docker.APIEvents{Action: v.action}); got != v.want {
+			t.Fatalf("wanted tracked %q: %v, got: %v", v.action, v.want, got)
+		}
+	}
+}